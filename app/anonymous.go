@@ -0,0 +1,112 @@
+package app
+
+import (
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/kthxat/filament/backends"
+	"github.com/kthxat/filament/backends/acl"
+	"github.com/kthxat/filament/config"
+)
+
+// anonymousMutex makes sure concurrent first visitors don't create more than
+// one anonymous session.
+var anonymousMutex sync.Mutex
+
+// AuthenticateAnonymous returns the ID of the session shared by all visitors
+// who did not log in, creating it if necessary. If anonymous access is
+// disabled or the backends refuse the configured credentials, an empty string
+// is returned.
+func AuthenticateAnonymous() (sid string) {
+	cfg := config.GetConfig().Anonymous
+	if cfg == nil || !cfg.Enabled {
+		return
+	}
+
+	anonymousMutex.Lock()
+	defer anonymousMutex.Unlock()
+
+	if sid = getAnonymousSession(); len(sid) > 0 {
+		return
+	}
+
+	session := newSession(cfg.Username, cfg.Password)
+	if session == nil {
+		return
+	}
+	session.isAnonymous = true
+	session.storage = acl.New(session.storage, anonymousRules(cfg.Paths), acl.Hide)
+
+	sid = registerSession(session)
+	return
+}
+
+// isAnonymousUsername returns whether the given username is the one the
+// anonymous session logs into the backends with. Case is ignored, as is the
+// password, since public FTP servers accept any password for it.
+func isAnonymousUsername(username string) bool {
+	cfg := config.GetConfig().Anonymous
+	return cfg != nil && strings.EqualFold(username, cfg.Username)
+}
+
+func getAnonymousSession() (id string) {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	unsyncedGC()
+
+	for sessionID, session := range sessions {
+		if session.isAnonymous {
+			id = sessionID
+			return
+		}
+	}
+
+	return
+}
+
+// anonymousRules builds access rules that only allow looking at and
+// downloading paths matching the given patterns. The directories leading to
+// these paths may be listed so they can be navigated to.
+func anonymousRules(patterns []string) (rules []*acl.Rule) {
+	if len(patterns) == 0 {
+		patterns = []string{"/**"}
+	}
+
+	readOnly := []backends.Operation{
+		backends.OperationList,
+		backends.OperationRead,
+		backends.OperationArchive,
+	}
+
+	for _, pattern := range patterns {
+		rules = append(rules, &acl.Rule{
+			Pattern:    pattern,
+			Operations: readOnly,
+			Effect:     acl.Allow,
+		})
+
+		// Every parent directory up to the first element containing
+		// wildcards needs to be listable
+		dir := "/"
+		rules = append(rules, &acl.Rule{
+			Pattern:    dir,
+			Operations: []backends.Operation{backends.OperationList},
+			Effect:     acl.Allow,
+		})
+		for _, element := range strings.Split(strings.Trim(path.Clean("/"+pattern), "/"), "/") {
+			if strings.ContainsAny(element, `*?[\`) {
+				break
+			}
+			dir = path.Join(dir, element)
+			rules = append(rules, &acl.Rule{
+				Pattern:    dir,
+				Operations: []backends.Operation{backends.OperationList},
+				Effect:     acl.Allow,
+			})
+		}
+	}
+
+	return
+}
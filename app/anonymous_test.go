@@ -0,0 +1,75 @@
+package app
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/kthxat/filament/backends"
+	"github.com/kthxat/filament/internal/storagetest"
+	"github.com/spf13/viper"
+)
+
+// testBackend accepts any password for the anonymous login, like public FTP
+// servers do, and "secret" for everyone else.
+type testBackend struct {
+	backends.Storage
+}
+
+func (b *testBackend) Authenticate(username, password string) (bool, error) {
+	return username == "anonymous" || password == "secret", nil
+}
+
+func TestAuthenticateAnonymousUsername(t *testing.T) {
+	storage := storagetest.Local(t, map[string]string{
+		"pub/a.txt":     "a",
+		"private/b.txt": "b",
+	})
+	backends.Register(&backends.BackendDescriptor{
+		ID:          "anonymoustest",
+		DisplayName: "Anonymous test",
+		New: func(*backends.BackendConstructionParams) (backends.Backend, error) {
+			return &testBackend{storage}, nil
+		},
+	})
+	viper.Set("Backends.anonymoustest.Enabled", true)
+	viper.Set("Anonymous.Username", "anonymous")
+	viper.Set("Anonymous.Password", "anonymous")
+	viper.Set("Anonymous.Paths", []string{"/pub/**"})
+	t.Cleanup(viper.Reset)
+
+	viper.Set("Anonymous.Enabled", false)
+	for _, username := range []string{"anonymous", "Anonymous"} {
+		if sid := Authenticate(username, "anonymous"); len(sid) > 0 {
+			t.Errorf("%s logged in while anonymous access is disabled", username)
+		}
+	}
+
+	viper.Set("Anonymous.Enabled", true)
+	anonymous := AuthenticateAnonymous()
+	if len(anonymous) == 0 {
+		t.Fatal("no anonymous session")
+	}
+	for _, password := range []string{"anonymous", "someone@example.com"} {
+		sid := Authenticate("anonymous", password)
+		if sid != anonymous {
+			t.Errorf("login with password %q did not result in the anonymous session", password)
+			continue
+		}
+		session := GetSessionByID(sid)
+		if !session.IsAnonymous() {
+			t.Errorf("login with password %q is not anonymous", password)
+		}
+		if _, err := session.Storage().ReadDir("/private"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("anonymous login lists /private: %v", err)
+		}
+	}
+
+	sid := Authenticate("alice", "secret")
+	if len(sid) == 0 || sid == anonymous {
+		t.Fatal("alice did not get a separate session")
+	}
+	if GetSessionByID(sid).IsAnonymous() {
+		t.Error("alice's session is anonymous")
+	}
+}
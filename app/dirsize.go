@@ -0,0 +1,74 @@
+package app
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"github.com/kthxat/filament/config"
+)
+
+// DirSize is the total size of everything below a directory.
+type DirSize struct {
+	Size  int64
+	Files int
+
+	// Incomplete is set if some directories could not be read
+	Incomplete bool
+
+	Calculated time.Time
+}
+
+func dirSizeCacheTTL() time.Duration {
+	if cfg := config.GetConfig().DirectorySize; cfg != nil {
+		return cfg.CacheTTL
+	}
+	return 0
+}
+
+// CachedDirSize returns the size calculated for the given directory before,
+// or nil if it is not known or outdated.
+func (s *Session) CachedDirSize(p string) *DirSize {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	size, ok := s.dirSizes[path.Clean("/"+p)]
+	if !ok {
+		return nil
+	}
+	if time.Since(size.Calculated) > dirSizeCacheTTL() {
+		delete(s.dirSizes, path.Clean("/"+p))
+		return nil
+	}
+	return size
+}
+
+// CacheDirSize remembers the size calculated for the given directory, if
+// caching is enabled.
+func (s *Session) CacheDirSize(p string, size *DirSize) {
+	if dirSizeCacheTTL() <= 0 {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.dirSizes == nil {
+		s.dirSizes = map[string]*DirSize{}
+	}
+	s.dirSizes[path.Clean("/"+p)] = size
+}
+
+// ForgetDirSizes drops the sizes cached for the given directory and
+// everything below it.
+func (s *Session) ForgetDirSizes(p string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p = path.Clean("/" + p)
+	for cached := range s.dirSizes {
+		if p == "/" || cached == p || strings.HasPrefix(cached, p+"/") {
+			delete(s.dirSizes, cached)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kthxat/filament/backends"
+	"github.com/kthxat/filament/backends/index"
+	"github.com/kthxat/filament/config"
+	"go.uber.org/multierr"
+)
+
+var errNoIndexBackend = errors.New("no backend to index configured")
+
+var (
+	searchIndex          *index.Index
+	searchIndexBackendID string
+	searchIndexMutex     sync.Mutex
+)
+
+// StartIndexing loads the saved search index and keeps crawling the
+// configured directories in the background, if the index is enabled.
+func StartIndexing() {
+	cfg := config.GetConfig()
+	indexConfig := cfg.Index
+	if indexConfig == nil || !indexConfig.Enabled {
+		return
+	}
+
+	backendID, err := indexBackendID(cfg)
+	if err != nil {
+		log.Printf("Setting up search index threw an error, searches will walk the backends: %s",
+			err.Error())
+		return
+	}
+
+	idx := index.NewIndex()
+	if len(indexConfig.File) > 0 {
+		if err := idx.Load(indexConfig.File); err != nil {
+			log.Printf("Loading search index threw an error: %s", err.Error())
+		}
+	}
+	idx.Keep(indexConfig.Roots)
+
+	searchIndexMutex.Lock()
+	searchIndex, searchIndexBackendID = idx, backendID
+	searchIndexMutex.Unlock()
+
+	go crawlLoop(idx, backendID, indexConfig)
+}
+
+// indexOf returns the search index of the given backend, or nil if it has
+// none.
+func indexOf(backendID string) *index.Index {
+	searchIndexMutex.Lock()
+	defer searchIndexMutex.Unlock()
+	if searchIndexBackendID != backendID {
+		return nil
+	}
+	return searchIndex
+}
+
+// indexBackendID returns the ID of the backend the search index is crawled
+// from, which is the same ID sessions use for it.
+func indexBackendID(cfg *config.Config) (string, error) {
+	if len(cfg.Mounts) > 0 {
+		return "mounts", nil
+	}
+	if len(cfg.Index.Backend) > 0 {
+		return cfg.Index.Backend, nil
+	}
+	for _, backendDescriptor := range backends.GetAll() {
+		if config.GetBackendConfig(backendDescriptor.ID) != nil {
+			return backendDescriptor.ID, nil
+		}
+	}
+	return "", errNoIndexBackend
+}
+
+// crawlRetryDelay is how long crawling waits before trying again after it
+// failed for the first time. The delay doubles with every further failure,
+// up to the interval.
+const crawlRetryDelay = time.Minute
+
+// crawlLoop crawls the configured directories every interval. Directories
+// loaded from a saved index are only crawled again once they are due.
+func crawlLoop(idx *index.Index, backendID string, indexConfig *config.IndexConfig) {
+	var retryDelay time.Duration
+	for {
+		// Wait for the directory that is due first
+		var due time.Time
+		for i, root := range indexConfig.Roots {
+			if next := idx.Crawled(root).Add(indexConfig.Interval); i == 0 || next.Before(due) {
+				due = next
+			}
+		}
+		// Directories that failed to be crawled are due right away, which
+		// must not make the loop hammer the backend
+		if retry := time.Now().Add(retryDelay); retry.After(due) {
+			due = retry
+		}
+		time.Sleep(time.Until(due))
+
+		if crawlIndex(idx, backendID, indexConfig) {
+			retryDelay = 0
+		} else {
+			retryDelay = min(max(2*retryDelay, crawlRetryDelay), indexConfig.Interval)
+		}
+
+		if indexConfig.Interval <= 0 {
+			return
+		}
+	}
+}
+
+// crawlIndex crawls all configured directories once and saves the index. It
+// returns whether all of them could be crawled.
+func crawlIndex(idx *index.Index, backendID string, indexConfig *config.IndexConfig) bool {
+	storage, err := indexStorage(backendID, indexConfig)
+	if err != nil {
+		log.Printf("Logging into backend %s to crawl search index threw an error: %s",
+			backendID, err.Error())
+		return false
+	}
+	defer func() {
+		if err := storage.Close(); err != nil {
+			log.Printf("Closing of backend %s threw an error: %s",
+				backendID, err.Error())
+		}
+	}()
+
+	ok := true
+	for _, root := range indexConfig.Roots {
+		start := time.Now()
+		if err := idx.Crawl(storage, root); err != nil {
+			log.Printf("Crawling %s for search index threw an error: %s",
+				root, err.Error())
+			ok = false
+			continue
+		}
+		log.Printf("Crawled %s for search index in %s", root, time.Since(start))
+	}
+
+	if len(indexConfig.File) > 0 {
+		if err := idx.Save(indexConfig.File); err != nil {
+			log.Printf("Saving search index threw an error: %s", err.Error())
+		}
+	}
+	return ok
+}
+
+// indexStorage logs into the backend to crawl with the service account of
+// the index.
+func indexStorage(backendID string, indexConfig *config.IndexConfig) (storage backends.Storage, err error) {
+	if backendID == "mounts" {
+		return mountStorage(indexConfig.Username, indexConfig.Password, config.GetConfig().Mounts), nil
+	}
+
+	descriptor := backends.GetByID(backendID)
+	if descriptor == nil {
+		err = fmt.Errorf("unknown backend %q", backendID)
+		return
+	}
+
+	backend, err := constructBackend(descriptor)
+	if err != nil {
+		return
+	}
+
+	storage, ok := backend.(backends.Storage)
+	if !ok {
+		err = multierr.Append(errNotAStorage, backend.Close())
+		return
+	}
+
+	if authenticator, ok := backend.(backends.Authenticator); ok {
+		ok, err = authenticator.Authenticate(indexConfig.Username, indexConfig.Password)
+		if err == nil && !ok {
+			err = errAuthenticationFailed
+		}
+		if err != nil {
+			err = multierr.Append(err, backend.Close())
+			storage = nil
+			return
+		}
+	}
+
+	return
+}
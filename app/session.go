@@ -52,12 +52,47 @@ func constructBackend(descriptor *backends.BackendDescriptor) (backend backends.
 	return
 }
 
+// Authenticate returns the ID of a session for the given credentials, reusing
+// an existing session of the same account. If the backends refuse the
+// credentials, an empty string is returned.
 func Authenticate(username, password string) (sid string) {
+	if isAnonymousUsername(username) {
+		// A session of its own would escape the restrictions of anonymous
+		// access, so whoever logs in like this is an anonymous visitor.
+		return AuthenticateAnonymous()
+	}
+
 	if sid = GetSessionByAccount(username, password); len(sid) > 0 {
 		return sid
 	}
 
+	if session := newSession(username, password); session != nil {
+		sid = registerSession(session)
+	}
+	return sid
+}
+
+// registerSession makes a new session available under a newly generated ID.
+func registerSession(session *Session) (sid string) {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	sid = xid.New().String()
+	sessions[sid] = session
+	go session.timeoutLoop()
+	return
+}
+
+// newSession logs into the first backend that accepts the given credentials
+// and creates a session for it. If no backend accepts the credentials, nil is
+// returned.
+func newSession(username, password string) *Session {
 	for _, backendDescriptor := range backends.GetAll() {
+		if config.GetBackendConfig(backendDescriptor.ID) == nil {
+			// Backend is not configured, it can only be used as a mount
+			continue
+		}
+
 		backend, err := constructBackend(backendDescriptor)
 		if err != nil {
 			log.Printf("Construction of authenticator %s threw an error: %s",
@@ -72,6 +107,7 @@ func Authenticate(username, password string) (sid string) {
 				log.Printf("Closing of backend threw an error: %s",
 					err)
 			}
+			continue
 		}
 		ok, err = authenticator.Authenticate(username, password)
 		if err != nil {
@@ -94,8 +130,16 @@ func Authenticate(username, password string) (sid string) {
 			continue
 		}
 
-		sessionsMutex.Lock()
-		defer sessionsMutex.Unlock()
+		storage, err = sessionStorage(backendDescriptor.ID, username, password, storage)
+		if err != nil {
+			if err := backend.Close(); err != nil {
+				log.Printf("Closing of backend %s threw an error: %s",
+					backendDescriptor.ID, err.Error())
+			}
+			log.Printf("Setting up storage of backend %s for user %s threw an error: %s",
+				backendDescriptor.ID, username, err.Error())
+			continue
+		}
 
 		passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), 14)
 		if err != nil {
@@ -103,7 +147,7 @@ func Authenticate(username, password string) (sid string) {
 				err.Error())
 			continue
 		}
-		session := &Session{
+		return &Session{
 			updateChan:    make(chan interface{}),
 			username:      username,
 			passwordHash:  passwordHash,
@@ -111,12 +155,8 @@ func Authenticate(username, password string) (sid string) {
 			authenticator: authenticator,
 			storage:       storage,
 		}
-		sid = xid.New().String()
-		sessions[sid] = session
-		go session.timeoutLoop()
-		return
 	}
-	return sid
+	return nil
 }
 
 func GetSessionByAccount(username, password string) (id string) {
@@ -127,7 +167,8 @@ func GetSessionByAccount(username, password string) (id string) {
 
 	// Find an existing active session to reuse
 	for sessionID, session := range sessions {
-		if session.username == username &&
+		if !session.isAnonymous &&
+			session.username == username &&
 			session.VerifyPassword(password) {
 			id = sessionID
 			return
@@ -147,9 +188,16 @@ type Session struct {
 	storage       backends.Storage
 	activeClients int
 
-	isActive bool
+	isActive    bool
+	isAnonymous bool
 
 	language string
+
+	// showHidden is set if the user chose to see hidden files
+	showHidden bool
+
+	// dirSizes caches calculated directory sizes by path
+	dirSizes map[string]*DirSize
 }
 
 func (s *Session) Increment() {
@@ -180,6 +228,14 @@ func (s *Session) IsActive() bool {
 	return s.isActive
 }
 
+// IsAnonymous returns whether this session is shared by all visitors who did
+// not log in.
+func (s *Session) IsAnonymous() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.isAnonymous
+}
+
 func (s *Session) Username() string {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -226,6 +282,30 @@ func (s *Session) SetLanguage(value string) {
 	s.language = value
 }
 
+// CanShowHidden returns whether the user may choose to see files hidden by
+// the configured hide patterns.
+func (s *Session) CanShowHidden() bool {
+	cfg := config.GetConfig().Hide
+	if cfg == nil || !cfg.AllowShowing || s.IsAnonymous() {
+		return false
+	}
+	_, ok := s.Storage().(backends.Filterer)
+	return ok
+}
+
+// ShowHidden returns whether the user chose to see hidden files.
+func (s *Session) ShowHidden() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.showHidden
+}
+
+func (s *Session) SetShowHidden(value bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.showHidden = value
+}
+
 func (s *Session) timeoutLoop() {
 	for {
 		select {
@@ -0,0 +1,509 @@
+package app
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kthxat/filament/backends"
+	"github.com/kthxat/filament/backends/acl"
+	"github.com/kthxat/filament/backends/chroot"
+	"github.com/kthxat/filament/config"
+	"github.com/rs/xid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrSharesDisabled = errors.New("sharing is disabled")
+	ErrShareNotFound  = errors.New("share does not exist")
+	ErrShareExhausted = errors.New("share has reached its download limit")
+	errShareLogin     = errors.New("logging in as share owner failed")
+)
+
+// Share gives people without an account read-only access to a file or
+// folder of a user.
+type Share struct {
+	ID      string
+	Owner   string
+	Path    string
+	IsDir   bool
+	Created time.Time
+	Expires time.Time
+
+	// PasswordHash is set if visitors need to enter a password first.
+	PasswordHash []byte `json:",omitempty"`
+
+	// MaxDownloads limits how often files may be downloaded through this
+	// share. If 0, there is no limit.
+	MaxDownloads int
+	Downloads    int
+
+	// Credentials is the encrypted password of the owner, which is used to
+	// log into the backends on behalf of visitors.
+	Credentials []byte
+}
+
+// ShareOptions are the settings a user can choose when creating a share.
+type ShareOptions struct {
+	Lifetime     time.Duration
+	Password     string
+	MaxDownloads int
+}
+
+var (
+	shares        = map[string]*Share{}
+	sharesLoaded  bool
+	shareSessions = map[string]string{}
+	sharesMutex   sync.Mutex
+
+	shareSecret     []byte
+	shareSecretOnce sync.Once
+)
+
+func sharesConfig() (*config.SharesConfig, error) {
+	cfg := config.GetConfig().Shares
+	if cfg == nil || !cfg.Enabled {
+		return nil, ErrSharesDisabled
+	}
+	return cfg, nil
+}
+
+// shareKey derives a key for the given purpose from the configured secret.
+func shareKey(cfg *config.SharesConfig, purpose string) []byte {
+	shareSecretOnce.Do(func() {
+		if len(cfg.Secret) > 0 {
+			shareSecret = []byte(cfg.Secret)
+			return
+		}
+		log.Println("No secret configured for shares, generating a random one. Share links will stop working on restart.")
+		shareSecret = make([]byte, 32)
+		if _, err := rand.Read(shareSecret); err != nil {
+			panic(err)
+		}
+	})
+	mac := hmac.New(sha256.New, shareSecret)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func sealCredentials(cfg *config.SharesConfig, password string) ([]byte, error) {
+	aead, err := credentialsCipher(cfg)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, []byte(password), nil), nil
+}
+
+func openCredentials(cfg *config.SharesConfig, sealed []byte) (string, error) {
+	aead, err := credentialsCipher(cfg)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errShareLogin
+	}
+	password, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(password), nil
+}
+
+func credentialsCipher(cfg *config.SharesConfig) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(shareKey(cfg, "credentials"))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (share *Share) signature(cfg *config.SharesConfig) []byte {
+	mac := hmac.New(sha256.New, shareKey(cfg, "links"))
+	fmt.Fprintf(mac, "%s|%d", share.ID, share.Expires.Unix())
+	return mac.Sum(nil)[:18]
+}
+
+// unsyncedLoadShares reads the saved shares on first use.
+func unsyncedLoadShares(cfg *config.SharesConfig) {
+	if sharesLoaded {
+		return
+	}
+	sharesLoaded = true
+
+	if len(cfg.StorePath) == 0 {
+		return
+	}
+
+	f, err := os.Open(cfg.StorePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	} else if err != nil {
+		log.Printf("Loading shares threw an error: %s", err.Error())
+		return
+	}
+	defer f.Close()
+
+	loadedShares := []*Share{}
+	if err := json.NewDecoder(f).Decode(&loadedShares); err != nil {
+		log.Printf("Loading shares threw an error: %s", err.Error())
+		return
+	}
+	for _, share := range loadedShares {
+		shares[share.ID] = share
+	}
+}
+
+// unsyncedSaveShares removes expired shares and writes the remaining ones to
+// the configured file.
+func unsyncedSaveShares(cfg *config.SharesConfig) error {
+	now := time.Now()
+	sortedShares := make([]*Share, 0, len(shares))
+	for id, share := range shares {
+		if now.After(share.Expires) {
+			delete(shares, id)
+			delete(shareSessions, id)
+			continue
+		}
+		sortedShares = append(sortedShares, share)
+	}
+
+	if len(cfg.StorePath) == 0 {
+		return nil
+	}
+
+	sort.Slice(sortedShares, func(i, j int) bool {
+		return sortedShares[i].Created.Before(sortedShares[j].Created)
+	})
+
+	// Write to a temporary file first so a crash can't leave a broken file
+	f, err := os.CreateTemp(filepath.Dir(cfg.StorePath), filepath.Base(cfg.StorePath)+".*")
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "\t")
+	if err := encoder.Encode(sortedShares); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), cfg.StorePath)
+}
+
+// CreateShare creates a share for the given path of the owner's storage. The
+// owner's password is needed to access the storage on behalf of visitors.
+func CreateShare(owner, ownerPassword, sharedPath string, isDir bool, options *ShareOptions) (*Share, error) {
+	cfg, err := sharesConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	lifetime := options.Lifetime
+	if lifetime <= 0 {
+		lifetime = cfg.DefaultLifetime
+	}
+	if cfg.MaxLifetime > 0 && lifetime > cfg.MaxLifetime {
+		lifetime = cfg.MaxLifetime
+	}
+
+	credentials, err := sealCredentials(cfg, ownerPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	share := &Share{
+		ID:           xid.New().String(),
+		Owner:        owner,
+		Path:         path.Clean("/" + sharedPath),
+		IsDir:        isDir,
+		Created:      now,
+		Expires:      now.Add(lifetime),
+		MaxDownloads: options.MaxDownloads,
+		Credentials:  credentials,
+	}
+	if len(options.Password) > 0 {
+		share.PasswordHash, err = bcrypt.GenerateFromPassword([]byte(options.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sharesMutex.Lock()
+	defer sharesMutex.Unlock()
+
+	unsyncedLoadShares(cfg)
+	shares[share.ID] = share
+	if err := unsyncedSaveShares(cfg); err != nil {
+		delete(shares, share.ID)
+		return nil, err
+	}
+
+	shareCopy := *share
+	return &shareCopy, nil
+}
+
+// SharesOf returns all active shares of the given user, newest first.
+func SharesOf(owner string) (ownedShares []*Share) {
+	cfg, err := sharesConfig()
+	if err != nil {
+		return
+	}
+
+	sharesMutex.Lock()
+	defer sharesMutex.Unlock()
+
+	unsyncedLoadShares(cfg)
+	now := time.Now()
+	for _, share := range shares {
+		if share.Owner == owner && now.Before(share.Expires) {
+			shareCopy := *share
+			ownedShares = append(ownedShares, &shareCopy)
+		}
+	}
+	sort.Slice(ownedShares, func(i, j int) bool {
+		return ownedShares[i].Created.After(ownedShares[j].Created)
+	})
+	return
+}
+
+// RevokeShare deletes a share of the given user.
+func RevokeShare(owner, id string) error {
+	cfg, err := sharesConfig()
+	if err != nil {
+		return err
+	}
+
+	sharesMutex.Lock()
+	defer sharesMutex.Unlock()
+
+	unsyncedLoadShares(cfg)
+	share, ok := shares[id]
+	if !ok || share.Owner != owner {
+		return ErrShareNotFound
+	}
+	delete(shares, id)
+	delete(shareSessions, id)
+	return unsyncedSaveShares(cfg)
+}
+
+// ShareToken returns the signed token which identifies the share in links.
+func ShareToken(share *Share) string {
+	cfg, err := sharesConfig()
+	if err != nil {
+		return ""
+	}
+	return share.ID + "." + base64.RawURLEncoding.EncodeToString(share.signature(cfg))
+}
+
+// ResolveShare returns the share identified by a token from a link. Tokens
+// with an invalid signature as well as expired shares are reported as
+// nonexistent.
+func ResolveShare(token string) (*Share, error) {
+	cfg, err := sharesConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	id, encodedSignature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrShareNotFound
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return nil, ErrShareNotFound
+	}
+
+	sharesMutex.Lock()
+	defer sharesMutex.Unlock()
+
+	unsyncedLoadShares(cfg)
+	share, ok := shares[id]
+	if !ok ||
+		!hmac.Equal(signature, share.signature(cfg)) ||
+		time.Now().After(share.Expires) {
+		return nil, ErrShareNotFound
+	}
+
+	shareCopy := *share
+	return &shareCopy, nil
+}
+
+// HasPassword returns whether visitors need to enter a password.
+func (share *Share) HasPassword() bool {
+	return len(share.PasswordHash) > 0
+}
+
+// VerifyPassword checks the password entered by a visitor.
+func (share *Share) VerifyPassword(password string) bool {
+	return nil == bcrypt.CompareHashAndPassword(share.PasswordHash, []byte(password))
+}
+
+// UnlockToken returns a value that proves a visitor has entered the password
+// of this share. It changes whenever the share or its password does.
+func (share *Share) UnlockToken() string {
+	cfg, err := sharesConfig()
+	if err != nil {
+		return ""
+	}
+	mac := hmac.New(sha256.New, shareKey(cfg, "unlock"))
+	mac.Write([]byte(share.ID))
+	mac.Write(share.PasswordHash)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// VisitToken returns a value that proves a download through this share was
+// counted for a visit lasting until the given time.
+func (share *Share) VisitToken(expires time.Time) string {
+	cfg, err := sharesConfig()
+	if err != nil {
+		return ""
+	}
+	unix := strconv.FormatInt(expires.Unix(), 10)
+	return unix + "." + base64.RawURLEncoding.EncodeToString(share.visitSignature(cfg, unix))
+}
+
+// VerifyVisitToken returns whether a value was returned by VisitToken for a
+// visit that has not ended yet.
+func (share *Share) VerifyVisitToken(token string) bool {
+	cfg, err := sharesConfig()
+	if err != nil {
+		return false
+	}
+	unix, encodedSignature, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(unix, 10, 64)
+	if err != nil || time.Now().After(time.Unix(expires, 0)) {
+		return false
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	return err == nil && hmac.Equal(signature, share.visitSignature(cfg, unix))
+}
+
+func (share *Share) visitSignature(cfg *config.SharesConfig, expires string) []byte {
+	mac := hmac.New(sha256.New, shareKey(cfg, "visits"))
+	fmt.Fprintf(mac, "%s|%s", share.ID, expires)
+	return mac.Sum(nil)
+}
+
+// CountShareDownload records a download through the given share. If the
+// share has already reached its download limit, ErrShareExhausted is
+// returned instead.
+func CountShareDownload(id string) error {
+	cfg, err := sharesConfig()
+	if err != nil {
+		return err
+	}
+
+	sharesMutex.Lock()
+	defer sharesMutex.Unlock()
+
+	unsyncedLoadShares(cfg)
+	share, ok := shares[id]
+	if !ok {
+		return ErrShareNotFound
+	}
+	if share.MaxDownloads > 0 && share.Downloads >= share.MaxDownloads {
+		return ErrShareExhausted
+	}
+	share.Downloads++
+	return unsyncedSaveShares(cfg)
+}
+
+// OpenShare logs into the backends as the owner of the share and returns the
+// owner's session along with a read-only storage that only contains the
+// shared file or folder. The returned storage must not be closed, as that
+// would close the storage of the owner's session.
+func OpenShare(share *Share) (*Session, backends.Storage, error) {
+	cfg, err := sharesConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sharesMutex.Lock()
+	sid := shareSessions[share.ID]
+	sharesMutex.Unlock()
+
+	session := GetSessionByID(sid)
+	if session == nil {
+		password, err := openCredentials(cfg, share.Credentials)
+		if err != nil {
+			return nil, nil, err
+		}
+		sid = Authenticate(share.Owner, password)
+		if session = GetSessionByID(sid); session == nil {
+			return nil, nil, errShareLogin
+		}
+
+		sharesMutex.Lock()
+		shareSessions[share.ID] = sid
+		sharesMutex.Unlock()
+	}
+
+	return session, shareStorage(share, session.Storage()), nil
+}
+
+func shareStorage(share *Share, storage backends.Storage) backends.Storage {
+	readOnly := []backends.Operation{
+		backends.OperationList,
+		backends.OperationRead,
+		backends.OperationArchive,
+	}
+
+	if share.IsDir {
+		return acl.New(chroot.New(storage, share.Path), []*acl.Rule{
+			{Pattern: "/**", Operations: readOnly, Effect: acl.Allow},
+		}, acl.Deny)
+	}
+
+	// Shared files are presented in a directory of their own
+	dir, name := path.Split(share.Path)
+	return acl.New(chroot.New(storage, dir), []*acl.Rule{
+		{Pattern: "/", Operations: []backends.Operation{backends.OperationList}, Effect: acl.Allow},
+		{Pattern: "/" + escapePattern(name), Operations: readOnly, Effect: acl.Allow},
+	}, acl.Hide)
+}
+
+// escapePattern escapes all characters of a name that have a special
+// meaning in patterns.
+func escapePattern(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// SharesEnabled returns whether users may create shares.
+func SharesEnabled() bool {
+	_, err := sharesConfig()
+	return err == nil
+}
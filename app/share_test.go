@@ -0,0 +1,31 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestVisitToken(t *testing.T) {
+	viper.Set("Shares.Enabled", true)
+	viper.Set("Shares.Secret", "secret")
+	t.Cleanup(viper.Reset)
+
+	share := &Share{ID: "a"}
+	token := share.VisitToken(time.Now().Add(time.Minute))
+	if !share.VerifyVisitToken(token) {
+		t.Errorf("token %q is not accepted", token)
+	}
+	for name, token := range map[string]string{
+		"expired":     share.VisitToken(time.Now().Add(-time.Second)),
+		"other share": (&Share{ID: "b"}).VisitToken(time.Now().Add(time.Minute)),
+		"extended":    "9999999999" + token[len(token)-44:],
+		"empty":       "",
+		"garbage":     "x.y",
+	} {
+		if share.VerifyVisitToken(token) {
+			t.Errorf("%s token %q is accepted", name, token)
+		}
+	}
+}
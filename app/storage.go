@@ -0,0 +1,247 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/kthxat/filament/backends"
+	"github.com/kthxat/filament/backends/acl"
+	"github.com/kthxat/filament/backends/cache"
+	"github.com/kthxat/filament/backends/chroot"
+	"github.com/kthxat/filament/backends/filecache"
+	"github.com/kthxat/filament/backends/filter"
+	"github.com/kthxat/filament/backends/index"
+	"github.com/kthxat/filament/backends/mount"
+	"github.com/kthxat/filament/config"
+	"go.uber.org/multierr"
+)
+
+var (
+	errNotAStorage          = errors.New("backend does not provide storage")
+	errAuthenticationFailed = errors.New("authentication failed")
+	errUnsafeUsername       = errors.New("username can not be used in a path")
+)
+
+var (
+	sharedCacheStore      *cache.Store
+	sharedCacheStoreMutex sync.Mutex
+
+	fileCacheStore      *filecache.Store
+	fileCacheStoreErr   error
+	fileCacheStoreMutex sync.Mutex
+)
+
+// sessionStorage builds the storage a new session of the given user works
+// with from the storage of the backend the user authenticated against.
+func sessionStorage(backendID, username, password string, storage backends.Storage) (backends.Storage, error) {
+	cfg := config.GetConfig()
+
+	root, err := expandRoot(cfg.RootOf(username), username)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cfg.Mounts) > 0 {
+		storage = mountStorage(username, password, cfg.Mounts)
+		backendID = "mounts"
+	}
+
+	if idx := indexOf(backendID); idx != nil {
+		storage = index.New(storage, idx)
+	}
+
+	if cfg.Cache != nil && cfg.Cache.Enabled {
+		storage = cachedStorage(cfg.Cache, backendID, storage)
+	}
+
+	if cfg.ContentCache != nil && cfg.ContentCache.Enabled {
+		storage = fileCachedStorage(cfg.ContentCache, backendID, username, storage)
+	}
+
+	if len(root) > 0 {
+		storage = chroot.New(storage, root)
+	}
+
+	if cfg.Access != nil {
+		defaultEffect, rules, err := accessRules(cfg, username)
+		if err != nil {
+			return nil, multierr.Append(err, storage.Close())
+		}
+		storage = acl.New(storage, rules, defaultEffect)
+	}
+
+	if patterns := cfg.HidePatternsOf(username); len(patterns) > 0 {
+		storage = filter.New(storage, patterns)
+	}
+
+	return storage, nil
+}
+
+// accessRules compiles the configured access rules that apply to the given
+// user.
+func accessRules(cfg *config.Config, username string) (defaultEffect acl.Effect, rules []*acl.Rule, err error) {
+	if len(cfg.Access.Default) > 0 {
+		defaultEffect, err = acl.ParseEffect(cfg.Access.Default)
+		if err != nil {
+			return
+		}
+	}
+
+	groups := cfg.GroupsOf(username)
+	for _, ruleConfig := range cfg.Access.Rules {
+		if !ruleConfig.Matches(username, groups) {
+			continue
+		}
+
+		effect, err := acl.ParseEffect(ruleConfig.Effect)
+		if err != nil {
+			return defaultEffect, nil, err
+		}
+
+		operations := make([]backends.Operation, len(ruleConfig.Operations))
+		for i, opName := range ruleConfig.Operations {
+			operations[i], err = acl.ParseOperation(opName)
+			if err != nil {
+				return defaultEffect, nil, err
+			}
+		}
+
+		for _, pattern := range ruleConfig.Paths {
+			rules = append(rules, &acl.Rule{
+				Pattern:    pattern,
+				Operations: operations,
+				Effect:     effect,
+			})
+		}
+	}
+	return
+}
+
+// cachedStorage wraps a storage with a cache of the configured scope.
+func cachedStorage(cacheConfig *config.CacheConfig, backendID string, storage backends.Storage) backends.Storage {
+	if cacheConfig.Scope != config.CacheScopeShared {
+		return cache.New(storage, cache.NewStore(cacheConfig.TTL, cacheConfig.MaxEntries), "")
+	}
+
+	sharedCacheStoreMutex.Lock()
+	defer sharedCacheStoreMutex.Unlock()
+
+	if sharedCacheStore == nil {
+		sharedCacheStore = cache.NewStore(cacheConfig.TTL, cacheConfig.MaxEntries)
+	}
+	return cache.New(storage, sharedCacheStore, backendID)
+}
+
+// fileCachedStorage wraps a storage with the on-disk file cache. If the
+// cache can not be set up, the storage is returned as is.
+func fileCachedStorage(
+	contentCacheConfig *config.ContentCacheConfig,
+	backendID, username string,
+	storage backends.Storage,
+) backends.Storage {
+	fileCacheStoreMutex.Lock()
+	defer fileCacheStoreMutex.Unlock()
+
+	if fileCacheStore == nil && fileCacheStoreErr == nil {
+		var maxSize int64
+		maxSize, fileCacheStoreErr = config.ParseSize(contentCacheConfig.MaxSize)
+		if fileCacheStoreErr == nil {
+			fileCacheStore, fileCacheStoreErr = filecache.NewStore(contentCacheConfig.Directory, maxSize)
+		}
+		if fileCacheStoreErr != nil {
+			log.Printf("Setting up file cache threw an error, files will not be cached: %s",
+				fileCacheStoreErr.Error())
+		}
+	}
+	if fileCacheStoreErr != nil {
+		return storage
+	}
+
+	maxFileSize, err := config.ParseSize(contentCacheConfig.MaxFileSize)
+	if err != nil {
+		log.Printf("Invalid maximum file size for file cache, files will not be cached: %s",
+			err.Error())
+		return storage
+	}
+
+	namespace := backendID
+	if contentCacheConfig.Scope != config.CacheScopeShared {
+		namespace += "\x00" + username
+	}
+	return filecache.New(storage, fileCacheStore, namespace, maxFileSize)
+}
+
+// expandRoot fills in the placeholders of a root directory template.
+func expandRoot(rootTemplate, username string) (string, error) {
+	if strings.Contains(rootTemplate, "{username}") &&
+		(username == "" || username == "." || username == ".." ||
+			strings.ContainsAny(username, "/\\\x00")) {
+		return "", errUnsafeUsername
+	}
+	return strings.ReplaceAll(rootTemplate, "{username}", username), nil
+}
+
+// mountStorage combines all configured mounts into one storage. Mounts that
+// fail to set up are logged and left out.
+func mountStorage(username, password string, mountConfigs []*config.MountConfig) backends.Storage {
+	mounts := make([]*mount.Mount, 0, len(mountConfigs))
+	for _, mountConfig := range mountConfigs {
+		storage, err := constructMount(username, password, mountConfig)
+		if err != nil {
+			log.Printf("Mounting backend %s at %s threw an error: %s",
+				mountConfig.Backend, mountConfig.Path, err.Error())
+			continue
+		}
+		mounts = append(mounts, &mount.Mount{
+			Path:    mountConfig.Path,
+			Storage: storage,
+		})
+	}
+	return mount.New(username, mounts)
+}
+
+func constructMount(username, password string, mountConfig *config.MountConfig) (storage backends.Storage, err error) {
+	descriptor := backends.GetByID(mountConfig.Backend)
+	if descriptor == nil {
+		err = fmt.Errorf("unknown backend %q", mountConfig.Backend)
+		return
+	}
+
+	backendConfig, err := mountConfig.BackendConfig()
+	if err != nil {
+		return
+	}
+
+	backend, err := descriptor.New(&backends.BackendConstructionParams{
+		Config: backendConfig,
+	})
+	if err != nil {
+		return
+	}
+
+	storage, ok := backend.(backends.Storage)
+	if !ok {
+		err = multierr.Append(errNotAStorage, backend.Close())
+		return
+	}
+
+	if authenticator, ok := backend.(backends.Authenticator); ok {
+		if len(mountConfig.Username) > 0 {
+			username, password = mountConfig.Username, mountConfig.Password
+		}
+		ok, err = authenticator.Authenticate(username, password)
+		if err == nil && !ok {
+			err = errAuthenticationFailed
+		}
+		if err != nil {
+			err = multierr.Append(err, backend.Close())
+			storage = nil
+			return
+		}
+	}
+
+	return
+}
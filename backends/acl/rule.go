@@ -0,0 +1,119 @@
+package acl
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/kthxat/filament/backends"
+)
+
+// Effect decides what happens to an operation a rule applies to.
+type Effect int
+
+const (
+	// Allow permits the operation.
+	Allow Effect = iota
+	// Deny refuses the operation with a permission error.
+	Deny
+	// Hide refuses the operation and pretends the path does not exist.
+	Hide
+)
+
+// ParseEffect converts the textual representation of an effect as used in
+// configuration files.
+func ParseEffect(s string) (Effect, error) {
+	switch strings.ToLower(s) {
+	case "allow":
+		return Allow, nil
+	case "deny":
+		return Deny, nil
+	case "hide":
+		return Hide, nil
+	}
+	return Allow, fmt.Errorf("unknown access rule effect %q", s)
+}
+
+// ParseOperation converts the textual representation of an operation as used
+// in configuration files.
+func ParseOperation(s string) (backends.Operation, error) {
+	op := backends.Operation(strings.ToLower(s))
+	switch op {
+	case backends.OperationList,
+		backends.OperationRead,
+		backends.OperationWrite,
+		backends.OperationDelete,
+		backends.OperationArchive:
+		return op, nil
+	}
+	return "", fmt.Errorf("unknown operation %q", s)
+}
+
+// Rule applies an effect to operations on paths matching a pattern.
+type Rule struct {
+	// Pattern is a slash-separated glob pattern as understood by path.Match,
+	// with the addition that a "**" element matches any number of path
+	// elements, including none.
+	Pattern string
+
+	// Operations lists the operations the rule applies to. If empty, the rule
+	// applies to all operations.
+	Operations []backends.Operation
+
+	Effect Effect
+}
+
+// Applies returns whether the rule decides about the given operation on the
+// given path.
+func (r *Rule) Applies(p string, op backends.Operation) bool {
+	if len(r.Operations) > 0 {
+		found := false
+		for _, ruleOp := range r.Operations {
+			if ruleOp == op {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return MatchPath(r.Pattern, p)
+}
+
+// MatchPath reports whether the given path matches the given pattern. See
+// Rule.Pattern for the pattern syntax.
+func MatchPath(pattern, p string) bool {
+	return matchElements(splitPath(pattern), splitPath(p))
+}
+
+func splitPath(p string) []string {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if len(p) == 0 {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+func matchElements(pattern, elements []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Try to let "**" swallow as many elements as needed
+			for i := 0; i <= len(elements); i++ {
+				if matchElements(pattern[1:], elements[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elements) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], elements[0]); err != nil || !ok {
+			return false
+		}
+		pattern = pattern[1:]
+		elements = elements[1:]
+	}
+	return len(elements) == 0
+}
@@ -0,0 +1,77 @@
+package acl
+
+import (
+	"testing"
+
+	"github.com/kthxat/filament/backends"
+)
+
+func TestMatchPath(t *testing.T) {
+	for _, test := range []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"/", "/", true},
+		{"/", "/a", false},
+		{"/a", "/a", true},
+		{"/a", "a/", true},
+		{"/a", "/a/b", false},
+		{"/*", "/a", true},
+		{"/*", "/a/b", false},
+		{"/**", "/", true},
+		{"/**", "/a/b/c", true},
+		{"/a/**", "/a", true},
+		{"/a/**", "/a/b/c", true},
+		{"/a/**", "/b/a", false},
+		{"/**/*.txt", "/x.txt", true},
+		{"/**/*.txt", "/a/b/x.txt", true},
+		{"/**/*.txt", "/a/b/x.txt/c", false},
+		{"/a/**/c", "/a/c", true},
+		{"/a/**/c", "/a/b/b/c", true},
+		{"/a/**/c", "/a/b/c/d", false},
+		{"/[ab]", "/b", true},
+		{"/\\*", "/*", true},
+		{"/\\*", "/a", false},
+		{"/[", "/[", false},
+	} {
+		if got := MatchPath(test.pattern, test.path); got != test.want {
+			t.Errorf("MatchPath(%q, %q) = %v, want %v", test.pattern, test.path, got, test.want)
+		}
+	}
+}
+
+func TestRuleApplies(t *testing.T) {
+	rule := &Rule{
+		Pattern:    "/secret/**",
+		Operations: []backends.Operation{backends.OperationRead},
+		Effect:     Deny,
+	}
+	if !rule.Applies("/secret/x", backends.OperationRead) {
+		t.Error("rule does not apply to a listed operation on a matching path")
+	}
+	if rule.Applies("/secret/x", backends.OperationList) {
+		t.Error("rule applies to an operation that is not listed")
+	}
+	if rule.Applies("/public/x", backends.OperationRead) {
+		t.Error("rule applies to a path that does not match")
+	}
+
+	rule.Operations = nil
+	if !rule.Applies("/secret/x", backends.OperationArchive) {
+		t.Error("rule without operations does not apply to all operations")
+	}
+}
+
+func TestParseEffect(t *testing.T) {
+	for s, want := range map[string]Effect{"allow": Allow, "Deny": Deny, "HIDE": Hide} {
+		if got, err := ParseEffect(s); err != nil || got != want {
+			t.Errorf("ParseEffect(%q) = %v, %v, want %v", s, got, err, want)
+		}
+	}
+	if _, err := ParseEffect("maybe"); err == nil {
+		t.Error("ParseEffect accepted an unknown effect")
+	}
+	if _, err := ParseOperation("fly"); err == nil {
+		t.Error("ParseOperation accepted an unknown operation")
+	}
+}
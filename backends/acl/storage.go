@@ -0,0 +1,156 @@
+package acl
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/kthxat/filament/backends"
+)
+
+// Storage enforces access rules on top of another storage. Rules are
+// evaluated in order and the first rule that applies decides; if no rule
+// applies, the default effect is used.
+type Storage struct {
+	inner         backends.Storage
+	rules         []*Rule
+	defaultEffect Effect
+}
+
+// New wraps the given storage with the given rules.
+func New(inner backends.Storage, rules []*Rule, defaultEffect Effect) *Storage {
+	return &Storage{
+		inner:         inner,
+		rules:         rules,
+		defaultEffect: defaultEffect,
+	}
+}
+
+func (s *Storage) effect(p string, op backends.Operation) Effect {
+	for _, rule := range s.rules {
+		if rule.Applies(p, op) {
+			return rule.Effect
+		}
+	}
+	return s.defaultEffect
+}
+
+func (s *Storage) Authorize(p string, op backends.Operation) error {
+	p = path.Clean("/" + p)
+	switch s.effect(p, op) {
+	case Deny:
+		return &os.PathError{Op: string(op), Path: p, Err: os.ErrPermission}
+	case Hide:
+		return &os.PathError{Op: string(op), Path: p, Err: os.ErrNotExist}
+	}
+	return backends.Authorize(s.inner, p, op)
+}
+
+// authorizeVisible checks whether a path may be looked at, which is the case
+// if it may be either listed or read.
+func (s *Storage) authorizeVisible(p string) error {
+	listErr := s.Authorize(p, backends.OperationList)
+	if listErr == nil {
+		return nil
+	}
+	if s.Authorize(p, backends.OperationRead) == nil {
+		return nil
+	}
+	return listErr
+}
+
+func (s *Storage) Close() error {
+	return s.inner.Close()
+}
+
+func (s *Storage) IsLoggedInAs(username string) bool {
+	return s.inner.IsLoggedInAs(username)
+}
+
+func (s *Storage) Stat(p string) (os.FileInfo, error) {
+	if err := s.authorizeVisible(p); err != nil {
+		return nil, err
+	}
+	return s.inner.Stat(p)
+}
+
+func (s *Storage) ReadDir(p string) ([]os.FileInfo, error) {
+	if err := s.Authorize(p, backends.OperationList); err != nil {
+		return nil, err
+	}
+
+	files, err := s.inner.ReadDir(p)
+	if err != nil {
+		return nil, err
+	}
+
+	// Leave out everything the user may not see
+	visibleFiles := make([]os.FileInfo, 0, len(files))
+	for _, f := range files {
+		if s.Authorize(path.Join("/", p, f.Name()), backends.OperationList) == nil {
+			visibleFiles = append(visibleFiles, f)
+		}
+	}
+	return visibleFiles, nil
+}
+
+func (s *Storage) Retrieve(p string, w io.Writer) error {
+	if err := s.Authorize(p, backends.OperationRead); err != nil {
+		return err
+	}
+	return s.inner.Retrieve(p, w)
+}
+
+func (s *Storage) Invalidate(p string) {
+	backends.Invalidate(s.inner, p)
+}
+
+func (s *Storage) RetrieveRange(p string, offset, length int64, w io.Writer) error {
+	if err := s.Authorize(p, backends.OperationRead); err != nil {
+		return err
+	}
+	return backends.RetrieveRange(s.inner, p, offset, length, w)
+}
+
+func (s *Storage) Hash(p string, algo backends.HashAlgorithm) (string, error) {
+	if err := s.Authorize(p, backends.OperationRead); err != nil {
+		return "", err
+	}
+	return backends.HashWithoutReading(s.inner, p, algo)
+}
+
+// Search leaves out everything that would not show up when walking the
+// directory, which includes files in directories the user may not list.
+func (s *Storage) Search(p string, depth int, cb filepath.WalkFunc) error {
+	p = path.Clean("/" + p)
+	if err := s.Authorize(p, backends.OperationList); err != nil {
+		return err
+	}
+
+	listable := map[string]bool{p: true}
+	var isListable func(dir string) bool
+	isListable = func(dir string) bool {
+		ok, known := listable[dir]
+		if !known && dir == "/" {
+			// Not located below the searched directory
+			return false
+		}
+		if !known {
+			ok = isListable(path.Dir(dir)) && s.Authorize(dir, backends.OperationList) == nil
+			listable[dir] = ok
+		}
+		return ok
+	}
+
+	return backends.SearchWithoutWalking(s.inner, p, depth, func(pwd string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return cb(pwd, fi, err)
+		}
+		if !isListable(path.Clean("/"+pwd)) ||
+			s.Authorize(path.Join("/", pwd, fi.Name()), backends.OperationList) != nil {
+			return nil
+		}
+		return cb(pwd, fi, nil)
+	})
+}
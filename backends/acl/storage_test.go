@@ -0,0 +1,103 @@
+package acl_test
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/kthxat/filament/backends"
+	"github.com/kthxat/filament/backends/acl"
+	"github.com/kthxat/filament/internal/storagetest"
+)
+
+func newStorage(t *testing.T) backends.Storage {
+	inner := storagetest.Local(t, map[string]string{
+		"public/a.txt":        "a",
+		"public/secret.txt":   "s",
+		"public/nested/b.txt": "b",
+		"private/c.txt":       "c",
+		"noarchive/d.txt":     "d",
+	})
+	return acl.New(inner, []*acl.Rule{
+		{Pattern: "/private/**", Effect: acl.Hide},
+		{Pattern: "/**/secret.txt", Operations: []backends.Operation{backends.OperationRead}, Effect: acl.Deny},
+		{Pattern: "/noarchive", Operations: []backends.Operation{backends.OperationArchive}, Effect: acl.Deny},
+	}, acl.Allow)
+}
+
+func names(files []os.FileInfo) []string {
+	result := make([]string, len(files))
+	for i, fi := range files {
+		result[i] = fi.Name()
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestReadDirLeavesOutHiddenPaths(t *testing.T) {
+	storage := newStorage(t)
+
+	files, err := storage.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := names(files), []string{"noarchive", "public"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadDir listed %q, want %q", got, want)
+	}
+
+	if _, err := storage.ReadDir("/private"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ReadDir of a hidden directory returned %v, want a not-exist error", err)
+	}
+	if _, err := storage.Stat("/private/c.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat of a hidden file returned %v, want a not-exist error", err)
+	}
+}
+
+func TestDeniedOperations(t *testing.T) {
+	storage := newStorage(t)
+
+	if err := storage.Retrieve("/public/secret.txt", io.Discard); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("Retrieve of a denied file returned %v, want a permission error", err)
+	}
+	if _, err := storage.Stat("/public/secret.txt"); err != nil {
+		t.Errorf("Stat of a file that may only not be read returned %v", err)
+	}
+	if err := storage.Retrieve("/public/a.txt", io.Discard); err != nil {
+		t.Errorf("Retrieve of an allowed file returned %v", err)
+	}
+	if err := backends.Authorize(storage, "/noarchive", backends.OperationArchive); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("Authorize of a denied archive returned %v, want a permission error", err)
+	}
+	if err := backends.Authorize(storage, "/noarchive/d.txt", backends.OperationArchive); err != nil {
+		t.Errorf("Authorize of an allowed archive returned %v", err)
+	}
+}
+
+func TestSearchLeavesOutHiddenPaths(t *testing.T) {
+	storage := newStorage(t)
+
+	var found []string
+	err := backends.Search(storage, "/", 0, func(pwd string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		found = append(found, path.Join(pwd, fi.Name()))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(found)
+
+	want := []string{
+		"/noarchive", "/noarchive/d.txt", "/public", "/public/a.txt",
+		"/public/nested", "/public/nested/b.txt", "/public/secret.txt",
+	}
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("Search found\n%q\nwant\n%q", found, want)
+	}
+}
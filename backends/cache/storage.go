@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/kthxat/filament/backends"
+)
+
+const (
+	kindStat    = "stat"
+	kindReadDir = "readdir"
+	kindHash    = "hash:"
+)
+
+// Storage caches the results of Stat, ReadDir and Hash calls to another
+// storage.
+// File contents are not cached.
+type Storage struct {
+	inner     backends.Storage
+	store     *Store
+	namespace string
+}
+
+// New wraps the given storage, caching results in the given store. The
+// namespace separates the entries of storages sharing a store that do not
+// present the same files.
+func New(inner backends.Storage, store *Store, namespace string) *Storage {
+	return &Storage{
+		inner:     inner,
+		store:     store,
+		namespace: namespace,
+	}
+}
+
+func cleanPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+func (s *Storage) key(kind, p string) string {
+	return s.namespace + "\x00" + p + "\x00" + kind
+}
+
+func (s *Storage) Close() error {
+	return s.inner.Close()
+}
+
+func (s *Storage) IsLoggedInAs(username string) bool {
+	return s.inner.IsLoggedInAs(username)
+}
+
+func (s *Storage) Stat(p string) (os.FileInfo, error) {
+	p = cleanPath(p)
+	if e, ok := s.store.get(s.key(kindStat, p)); ok {
+		return e.info, nil
+	}
+
+	info, err := s.inner.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	s.store.put(&entry{key: s.key(kindStat, p), info: info})
+	return info, nil
+}
+
+func (s *Storage) ReadDir(p string) ([]os.FileInfo, error) {
+	p = cleanPath(p)
+	if e, ok := s.store.get(s.key(kindReadDir, p)); ok {
+		return copyFiles(e.files), nil
+	}
+
+	files, err := s.inner.ReadDir(p)
+	if err != nil {
+		return nil, err
+	}
+	s.store.put(&entry{key: s.key(kindReadDir, p), files: copyFiles(files)})
+
+	// The listing also tells everything Stat would about the files in it
+	for _, f := range files {
+		s.store.put(&entry{key: s.key(kindStat, path.Join(p, f.Name())), info: f})
+	}
+
+	return files, nil
+}
+
+// copyFiles copies a listing so callers can sort it without affecting the
+// cached listing.
+func copyFiles(files []os.FileInfo) []os.FileInfo {
+	filesCopy := make([]os.FileInfo, len(files))
+	copy(filesCopy, files)
+	return filesCopy
+}
+
+func (s *Storage) Retrieve(p string, w io.Writer) error {
+	return s.inner.Retrieve(p, w)
+}
+
+func (s *Storage) Authorize(p string, op backends.Operation) error {
+	return backends.Authorize(s.inner, p, op)
+}
+
+// Invalidate forgets everything cached about the given path, everything
+// below it and the listing of its parent directory.
+func (s *Storage) Invalidate(p string) {
+	p = cleanPath(p)
+	s.store.remove(s.key(kindStat, p))
+	s.store.remove(s.key(kindReadDir, p))
+	s.store.remove(s.key(kindReadDir, path.Dir(p)))
+	if p == "/" {
+		s.store.removePrefix(s.namespace + "\x00/")
+	} else {
+		s.store.removePrefix(s.namespace + "\x00" + p + "/")
+	}
+	backends.Invalidate(s.inner, p)
+}
+
+func (s *Storage) RetrieveRange(p string, offset, length int64, w io.Writer) error {
+	return backends.RetrieveRange(s.inner, p, offset, length, w)
+}
+
+// Hash caches checksums computed by the wrapped storage. As files may be
+// replaced under the same name, checksums are remembered along with the size
+// and modification time of the file they were computed for.
+func (s *Storage) Hash(p string, algo backends.HashAlgorithm) (string, error) {
+	p = cleanPath(p)
+	fi, err := s.Stat(p)
+	if err != nil {
+		return "", err
+	}
+	key := s.key(fmt.Sprintf("%s%s\x00%d\x00%d", kindHash, algo, fi.Size(), fi.ModTime().UnixNano()), p)
+	if e, ok := s.store.get(key); ok {
+		return e.sum, nil
+	}
+
+	sum, err := backends.HashWithoutReading(s.inner, p, algo)
+	if err != nil {
+		return "", err
+	}
+	s.store.put(&entry{key: key, sum: sum})
+	return sum, nil
+}
+
+func (s *Storage) Search(p string, depth int, cb filepath.WalkFunc) error {
+	return backends.SearchWithoutWalking(s.inner, p, depth, cb)
+}
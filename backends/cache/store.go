@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"container/list"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store keeps cached file information in memory. It evicts entries once
+// their time to live has passed or, if the store is full, the least recently
+// used ones first. A store may be shared by multiple storages.
+type Store struct {
+	mutex      sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]*list.Element
+	lru        *list.List
+}
+
+type entry struct {
+	key     string
+	expires time.Time
+	info    os.FileInfo
+	files   []os.FileInfo
+	sum     string
+}
+
+// NewStore creates a store whose entries expire after the given duration. If
+// maxEntries is 0 or less, the number of entries is not limited.
+func NewStore(ttl time.Duration, maxEntries int) *Store {
+	return &Store{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    map[string]*list.Element{},
+		lru:        list.New(),
+	}
+}
+
+func (s *Store) get(key string) (e *entry, ok bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	element, ok := s.entries[key]
+	if !ok {
+		return
+	}
+	e = element.Value.(*entry)
+	if time.Now().After(e.expires) {
+		s.unsyncedRemove(element)
+		return nil, false
+	}
+	s.lru.MoveToFront(element)
+	return
+}
+
+func (s *Store) put(e *entry) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	e.expires = time.Now().Add(s.ttl)
+	if element, ok := s.entries[e.key]; ok {
+		element.Value = e
+		s.lru.MoveToFront(element)
+		return
+	}
+	s.entries[e.key] = s.lru.PushFront(e)
+
+	for s.maxEntries > 0 && s.lru.Len() > s.maxEntries {
+		s.unsyncedRemove(s.lru.Back())
+	}
+}
+
+// removePrefix drops all entries whose key starts with the given prefix.
+func (s *Store) removePrefix(prefix string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for key, element := range s.entries {
+		if strings.HasPrefix(key, prefix) {
+			s.unsyncedRemove(element)
+		}
+	}
+}
+
+func (s *Store) remove(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if element, ok := s.entries[key]; ok {
+		s.unsyncedRemove(element)
+	}
+}
+
+func (s *Store) unsyncedRemove(element *list.Element) {
+	delete(s.entries, element.Value.(*entry).key)
+	s.lru.Remove(element)
+}
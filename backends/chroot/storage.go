@@ -0,0 +1,113 @@
+package chroot
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/kthxat/filament/backends"
+)
+
+// Storage confines all accesses to a directory of the wrapped storage, which
+// then appears as the root directory.
+type Storage struct {
+	inner backends.Storage
+	root  string
+}
+
+// New wraps the given storage so that only the given root directory and
+// everything below it is accessible.
+func New(inner backends.Storage, root string) *Storage {
+	return &Storage{
+		inner: inner,
+		root:  path.Clean("/" + root),
+	}
+}
+
+// Root returns the directory of the wrapped storage that serves as root.
+func (s *Storage) Root() string {
+	return s.root
+}
+
+// resolve maps a path inside the root directory to a path of the wrapped
+// storage. Cleaning the path as an absolute one first collapses any ".."
+// elements, so the result can never be located outside the root directory.
+func (s *Storage) resolve(p string) string {
+	return path.Join(s.root, path.Clean("/"+p))
+}
+
+// unresolve maps a path of the wrapped storage located inside the root
+// directory back to a path relative to the root directory.
+func (s *Storage) unresolve(p string) string {
+	if s.root == "/" {
+		return p
+	}
+	return path.Clean("/" + strings.TrimPrefix(p, s.root))
+}
+
+// hidePath makes sure errors do not reveal where the root directory is
+// located in the wrapped storage.
+func (s *Storage) hidePath(p string, err error) error {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) && strings.HasPrefix(pathErr.Path, s.root) {
+		return &os.PathError{
+			Op:   pathErr.Op,
+			Path: path.Clean("/" + p),
+			Err:  pathErr.Err,
+		}
+	}
+	return err
+}
+
+func (s *Storage) Close() error {
+	return s.inner.Close()
+}
+
+func (s *Storage) IsLoggedInAs(username string) bool {
+	return s.inner.IsLoggedInAs(username)
+}
+
+func (s *Storage) Stat(p string) (fi os.FileInfo, err error) {
+	fi, err = s.inner.Stat(s.resolve(p))
+	err = s.hidePath(p, err)
+	return
+}
+
+func (s *Storage) ReadDir(p string) (files []os.FileInfo, err error) {
+	files, err = s.inner.ReadDir(s.resolve(p))
+	err = s.hidePath(p, err)
+	return
+}
+
+func (s *Storage) Retrieve(p string, w io.Writer) (err error) {
+	err = s.inner.Retrieve(s.resolve(p), w)
+	err = s.hidePath(p, err)
+	return
+}
+
+func (s *Storage) Authorize(p string, op backends.Operation) error {
+	return s.hidePath(p, backends.Authorize(s.inner, s.resolve(p), op))
+}
+
+func (s *Storage) Invalidate(p string) {
+	backends.Invalidate(s.inner, s.resolve(p))
+}
+
+func (s *Storage) RetrieveRange(p string, offset, length int64, w io.Writer) error {
+	return s.hidePath(p, backends.RetrieveRange(s.inner, s.resolve(p), offset, length, w))
+}
+
+func (s *Storage) Hash(p string, algo backends.HashAlgorithm) (string, error) {
+	sum, err := backends.HashWithoutReading(s.inner, s.resolve(p), algo)
+	return sum, s.hidePath(p, err)
+}
+
+func (s *Storage) Search(p string, depth int, cb filepath.WalkFunc) error {
+	err := backends.SearchWithoutWalking(s.inner, s.resolve(p), depth, func(pwd string, fi os.FileInfo, err error) error {
+		return cb(s.unresolve(pwd), fi, s.hidePath(pwd, err))
+	})
+	return s.hidePath(p, err)
+}
@@ -0,0 +1,174 @@
+package filecache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/kthxat/filament/backends"
+)
+
+// Storage keeps the contents of retrieved files on local disk, so repeated
+// downloads of the same file don't have to go through the backend again.
+// Files are identified by their path, size and modification time, so
+// changed files are retrieved again.
+type Storage struct {
+	inner       backends.Storage
+	store       *Store
+	namespace   string
+	maxFileSize int64
+}
+
+// New wraps the given storage, caching file contents in the given store.
+// The namespace separates the files of storages sharing a store that do not
+// present the same files. Files larger than maxFileSize are not cached; if
+// maxFileSize is 0 or less, there is no limit.
+func New(inner backends.Storage, store *Store, namespace string, maxFileSize int64) *Storage {
+	return &Storage{
+		inner:       inner,
+		store:       store,
+		namespace:   namespace,
+		maxFileSize: maxFileSize,
+	}
+}
+
+// name returns the name the given file is cached under. If the file should
+// not be cached, an empty string is returned.
+func (s *Storage) name(p string) (string, int64) {
+	info, err := s.inner.Stat(p)
+	if err != nil || !info.Mode().IsRegular() ||
+		(s.maxFileSize > 0 && info.Size() > s.maxFileSize) {
+		return "", 0
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%d\x00%d", s.namespace, p, info.Size(), info.ModTime().UnixNano())
+	return hex.EncodeToString(h.Sum(nil)), info.Size()
+}
+
+func (s *Storage) Close() error {
+	return s.inner.Close()
+}
+
+func (s *Storage) IsLoggedInAs(username string) bool {
+	return s.inner.IsLoggedInAs(username)
+}
+
+func (s *Storage) Stat(p string) (os.FileInfo, error) {
+	return s.inner.Stat(p)
+}
+
+func (s *Storage) ReadDir(p string) ([]os.FileInfo, error) {
+	return s.inner.ReadDir(p)
+}
+
+func (s *Storage) Retrieve(p string, w io.Writer) error {
+	name, size := s.name(p)
+	if len(name) == 0 {
+		return s.inner.Retrieve(p, w)
+	}
+
+	if f := s.store.Open(name); f != nil {
+		defer f.Close()
+		_, err := io.Copy(w, f)
+		return err
+	}
+
+	// Pass the file through to the caller while filling the cache
+	f, err := s.store.Create()
+	if err != nil {
+		log.Printf("Creating cache file threw an error: %s", err.Error())
+		return s.inner.Retrieve(p, w)
+	}
+	fill := &fillWriter{file: f}
+	err = s.inner.Retrieve(p, io.MultiWriter(w, fill))
+	if err != nil || fill.err != nil || fill.written != size {
+		s.store.Discard(f)
+		return err
+	}
+	if err := s.store.Commit(f, name); err != nil {
+		log.Printf("Storing cache file threw an error: %s", err.Error())
+	}
+	return nil
+}
+
+func (s *Storage) RetrieveRange(p string, offset, length int64, w io.Writer) error {
+	name, _ := s.name(p)
+	if len(name) == 0 {
+		return backends.RetrieveRange(s.inner, p, offset, length, w)
+	}
+
+	f := s.store.Open(name)
+	if f == nil {
+		return backends.RetrieveRange(s.inner, p, offset, length, w)
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	var err error
+	if length < 0 {
+		_, err = io.Copy(w, f)
+	} else {
+		_, err = io.CopyN(w, f, length)
+	}
+	return err
+}
+
+func (s *Storage) Authorize(p string, op backends.Operation) error {
+	return backends.Authorize(s.inner, p, op)
+}
+
+func (s *Storage) Invalidate(p string) {
+	backends.Invalidate(s.inner, p)
+}
+
+// fillWriter writes to a cache file, remembering instead of returning
+// errors so a failing cache does not interrupt the download.
+type fillWriter struct {
+	file    *os.File
+	written int64
+	err     error
+}
+
+func (w *fillWriter) Write(p []byte) (int, error) {
+	if w.err == nil {
+		var n int
+		n, w.err = w.file.Write(p)
+		w.written += int64(n)
+	}
+	return len(p), nil
+}
+
+// Hash computes checksums of cached files locally. Other files are left to
+// the wrapped storage if it can compute checksums itself. Otherwise
+// ErrHashUnsupported is returned, so backends.Hash reads them through all
+// layers, including this cache, and they don't have to be retrieved again
+// for download.
+func (s *Storage) Hash(p string, algo backends.HashAlgorithm) (string, error) {
+	if name, _ := s.name(p); len(name) > 0 {
+		if f := s.store.Open(name); f != nil {
+			defer f.Close()
+
+			h, err := backends.NewHash(algo)
+			if err != nil {
+				return "", err
+			}
+			if _, err := io.Copy(h, f); err != nil {
+				return "", err
+			}
+			return hex.EncodeToString(h.Sum(nil)), nil
+		}
+	}
+
+	return backends.HashWithoutReading(s.inner, p, algo)
+}
+
+func (s *Storage) Search(p string, depth int, cb filepath.WalkFunc) error {
+	return backends.SearchWithoutWalking(s.inner, p, depth, cb)
+}
@@ -0,0 +1,182 @@
+package filecache
+
+import (
+	"container/list"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/multierr"
+)
+
+const tempFilePrefix = "tmp-"
+
+// Store keeps file contents in a directory on local disk. Once the files
+// take up more space than allowed, the least recently used ones are removed.
+// A store may be shared by multiple storages.
+type Store struct {
+	mutex   sync.Mutex
+	dir     string
+	maxSize int64
+	size    int64
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type entry struct {
+	name string
+	size int64
+}
+
+// NewStore opens a store in the given directory, creating it if necessary.
+// Files left over from a previous run are reused.
+func NewStore(dir string, maxSize int64) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		dir:     dir,
+		maxSize: maxSize,
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+	}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		if strings.HasPrefix(dirEntry.Name(), tempFilePrefix) {
+			// Incomplete download from a previous run
+			if err := os.Remove(filepath.Join(dir, dirEntry.Name())); err != nil {
+				log.Printf("Removing incomplete cache file threw an error: %s", err.Error())
+			}
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		infos = append(infos, info)
+	}
+
+	// Modification times are updated on use, so they tell the usage order
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().After(infos[j].ModTime())
+	})
+	for _, info := range infos {
+		s.entries[info.Name()] = s.lru.PushBack(&entry{
+			name: info.Name(),
+			size: info.Size(),
+		})
+		s.size += info.Size()
+	}
+
+	s.mutex.Lock()
+	s.unsyncedEvict()
+	s.mutex.Unlock()
+
+	return s, nil
+}
+
+// Open returns the cached file with the given name, or nil if it is not
+// cached.
+func (s *Store) Open(name string) *os.File {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	element, ok := s.entries[name]
+	if !ok {
+		return nil
+	}
+
+	p := filepath.Join(s.dir, name)
+	f, err := os.Open(p)
+	if err != nil {
+		// Someone else cleaned up behind our back
+		s.unsyncedRemove(element)
+		return nil
+	}
+
+	s.lru.MoveToFront(element)
+	now := time.Now()
+	if err := os.Chtimes(p, now, now); err != nil {
+		log.Printf("Updating cache file times threw an error: %s", err.Error())
+	}
+	return f
+}
+
+// Create starts a new cache file. It only becomes visible once committed.
+func (s *Store) Create() (*os.File, error) {
+	return os.CreateTemp(s.dir, tempFilePrefix+"*")
+}
+
+// Commit moves a completely written file created with Create into the store
+// under the given name.
+func (s *Store) Commit(f *os.File, name string) error {
+	info, err := f.Stat()
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		return multierr.Append(err, os.Remove(f.Name()))
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.maxSize > 0 && info.Size() > s.maxSize {
+		// Would not fit even in an empty cache
+		return os.Remove(f.Name())
+	}
+
+	if err := os.Rename(f.Name(), filepath.Join(s.dir, name)); err != nil {
+		return multierr.Append(err, os.Remove(f.Name()))
+	}
+
+	if element, ok := s.entries[name]; ok {
+		// Someone else cached the same file in the meantime
+		s.size -= element.Value.(*entry).size
+		s.lru.Remove(element)
+	}
+	s.entries[name] = s.lru.PushFront(&entry{
+		name: name,
+		size: info.Size(),
+	})
+	s.size += info.Size()
+	s.unsyncedEvict()
+	return nil
+}
+
+// Discard throws away a file created with Create.
+func (s *Store) Discard(f *os.File) {
+	f.Close()
+	if err := os.Remove(f.Name()); err != nil {
+		log.Printf("Removing incomplete cache file threw an error: %s", err.Error())
+	}
+}
+
+func (s *Store) unsyncedEvict() {
+	for s.maxSize > 0 && s.size > s.maxSize && s.lru.Len() > 0 {
+		element := s.lru.Back()
+		if err := os.Remove(filepath.Join(s.dir, element.Value.(*entry).name)); err != nil &&
+			!errors.Is(err, os.ErrNotExist) {
+			log.Printf("Removing cache file threw an error: %s", err.Error())
+		}
+		s.unsyncedRemove(element)
+	}
+}
+
+func (s *Store) unsyncedRemove(element *list.Element) {
+	e := element.Value.(*entry)
+	delete(s.entries, e.name)
+	s.size -= e.size
+	s.lru.Remove(element)
+}
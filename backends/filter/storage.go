@@ -0,0 +1,146 @@
+package filter
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/kthxat/filament/backends"
+	"github.com/kthxat/filament/backends/acl"
+)
+
+// Storage hides files matching patterns, as if they did not exist. Patterns
+// without a slash are matched against the names of files and of all the
+// directories they are located in, e.g. ".DS_Store" or ".*". Other patterns
+// are matched against the whole path, using the syntax of access rules.
+type Storage struct {
+	inner        backends.Storage
+	namePatterns []string
+	pathPatterns []string
+}
+
+// New wraps the given storage, hiding everything that matches any of the
+// given patterns.
+func New(inner backends.Storage, patterns []string) *Storage {
+	s := &Storage{inner: inner}
+	for _, pattern := range patterns {
+		if strings.Contains(pattern, "/") {
+			s.pathPatterns = append(s.pathPatterns, pattern)
+		} else {
+			s.namePatterns = append(s.namePatterns, pattern)
+		}
+	}
+	return s
+}
+
+// Unfiltered returns the wrapped storage, in which no files are hidden.
+func (s *Storage) Unfiltered() backends.Storage {
+	return s.inner
+}
+
+// IsHidden returns whether the given path or any of the directories it is
+// located in matches a pattern.
+func (s *Storage) IsHidden(p string) bool {
+	for p = path.Clean("/" + p); p != "/"; p = path.Dir(p) {
+		for _, pattern := range s.namePatterns {
+			if ok, err := path.Match(pattern, path.Base(p)); err == nil && ok {
+				return true
+			}
+		}
+		for _, pattern := range s.pathPatterns {
+			if acl.MatchPath(pattern, p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *Storage) check(op, p string) error {
+	if s.IsHidden(p) {
+		return &os.PathError{Op: op, Path: path.Clean("/" + p), Err: os.ErrNotExist}
+	}
+	return nil
+}
+
+func (s *Storage) Authorize(p string, op backends.Operation) error {
+	if err := s.check(string(op), p); err != nil {
+		return err
+	}
+	return backends.Authorize(s.inner, p, op)
+}
+
+func (s *Storage) Close() error {
+	return s.inner.Close()
+}
+
+func (s *Storage) IsLoggedInAs(username string) bool {
+	return s.inner.IsLoggedInAs(username)
+}
+
+func (s *Storage) Stat(p string) (os.FileInfo, error) {
+	if err := s.check("stat", p); err != nil {
+		return nil, err
+	}
+	return s.inner.Stat(p)
+}
+
+func (s *Storage) ReadDir(p string) ([]os.FileInfo, error) {
+	if err := s.check("readdir", p); err != nil {
+		return nil, err
+	}
+
+	files, err := s.inner.ReadDir(p)
+	if err != nil {
+		return nil, err
+	}
+
+	visibleFiles := make([]os.FileInfo, 0, len(files))
+	for _, f := range files {
+		if !s.IsHidden(path.Join("/", p, f.Name())) {
+			visibleFiles = append(visibleFiles, f)
+		}
+	}
+	return visibleFiles, nil
+}
+
+func (s *Storage) Retrieve(p string, w io.Writer) error {
+	if err := s.check("retrieve", p); err != nil {
+		return err
+	}
+	return s.inner.Retrieve(p, w)
+}
+
+func (s *Storage) Invalidate(p string) {
+	backends.Invalidate(s.inner, p)
+}
+
+func (s *Storage) RetrieveRange(p string, offset, length int64, w io.Writer) error {
+	if err := s.check("retrieve", p); err != nil {
+		return err
+	}
+	return backends.RetrieveRange(s.inner, p, offset, length, w)
+}
+
+func (s *Storage) Hash(p string, algo backends.HashAlgorithm) (string, error) {
+	if err := s.check("hash", p); err != nil {
+		return "", err
+	}
+	return backends.HashWithoutReading(s.inner, p, algo)
+}
+
+// Search leaves out hidden files and everything located in hidden
+// directories.
+func (s *Storage) Search(p string, depth int, cb filepath.WalkFunc) error {
+	if err := s.check("search", p); err != nil {
+		return err
+	}
+	return backends.SearchWithoutWalking(s.inner, p, depth, func(pwd string, fi os.FileInfo, err error) error {
+		if err == nil && s.IsHidden(path.Join("/", pwd, fi.Name())) {
+			return nil
+		}
+		return cb(pwd, fi, err)
+	})
+}
@@ -0,0 +1,118 @@
+package filter_test
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/kthxat/filament/backends"
+	"github.com/kthxat/filament/backends/filter"
+	"github.com/kthxat/filament/internal/storagetest"
+)
+
+func newStorage(t *testing.T) *filter.Storage {
+	inner := storagetest.Local(t, map[string]string{
+		"a.txt":             "a",
+		".DS_Store":         "x",
+		"docs/b.txt":        "b",
+		"docs/.git/HEAD":    "h",
+		"docs/build/c.o":    "c",
+		"docs/sub/build.md": "d",
+		"build/e.txt":       "e",
+	})
+	return filter.New(inner, []string{".*", "/docs/build"})
+}
+
+func names(files []os.FileInfo) []string {
+	result := make([]string, len(files))
+	for i, fi := range files {
+		result[i] = fi.Name()
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestIsHidden(t *testing.T) {
+	storage := newStorage(t)
+	for p, want := range map[string]bool{
+		"/":                     false,
+		"/a.txt":                false,
+		"/.DS_Store":            true,
+		"docs/.git":             true,
+		"/docs/.git/HEAD":       true,
+		"/docs/build":           true,
+		"/docs/build/c.o":       true,
+		"/docs/sub/build.md":    false,
+		"/build/e.txt":          false,
+		"/docs/./.git/../b.txt": false,
+	} {
+		if got := storage.IsHidden(p); got != want {
+			t.Errorf("IsHidden(%q) = %v, want %v", p, got, want)
+		}
+	}
+}
+
+func TestReadDirLeavesOutHiddenFiles(t *testing.T) {
+	storage := newStorage(t)
+
+	for dir, want := range map[string][]string{
+		"/":     {"a.txt", "build", "docs"},
+		"/docs": {"b.txt", "sub"},
+	} {
+		files, err := storage.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := names(files); !reflect.DeepEqual(got, want) {
+			t.Errorf("ReadDir(%q) listed %q, want %q", dir, got, want)
+		}
+	}
+
+	if _, err := storage.ReadDir("/docs/.git"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ReadDir of a hidden directory returned %v, want a not-exist error", err)
+	}
+	if _, err := storage.Stat("/docs/build/c.o"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat of a hidden file returned %v, want a not-exist error", err)
+	}
+	if err := storage.Retrieve("/.DS_Store", io.Discard); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Retrieve of a hidden file returned %v, want a not-exist error", err)
+	}
+}
+
+func TestSearchLeavesOutHiddenFiles(t *testing.T) {
+	storage := newStorage(t)
+
+	var found []string
+	err := backends.Search(storage, "/", 0, func(pwd string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		found = append(found, path.Join(pwd, fi.Name()))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(found)
+
+	want := []string{"/a.txt", "/build", "/build/e.txt", "/docs", "/docs/b.txt", "/docs/sub", "/docs/sub/build.md"}
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("Search found\n%q\nwant\n%q", found, want)
+	}
+}
+
+func TestUnfiltered(t *testing.T) {
+	storage := newStorage(t)
+
+	files, err := backends.Unfiltered(storage).ReadDir("/docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := names(files), []string{".git", "b.txt", "build", "sub"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unfiltered ReadDir listed %q, want %q", got, want)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net/url"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/kthxat/filament/backends"
@@ -23,14 +24,19 @@ type FTPBackendConfiguration struct {
 	ServerLocation     string
 	InsecureSkipVerify bool
 	TLSServerName      string
+
+	// ConnectionsPerHost limits the number of connections opened in
+	// parallel, e.g. while prefetching files for archives.
+	ConnectionsPerHost int
 }
 
 func (c *FTPBackendConfiguration) makeFTPClientConfig() (retval goftp.Config, err error) {
 	retval = goftp.Config{
-		IPv6Lookup:       c.IPv6Lookup,
-		ActiveTransfers:  c.ActiveTransfers,
-		ActiveListenAddr: c.ActiveListenAddr,
-		DisableEPSV:      c.DisableEPSV,
+		IPv6Lookup:         c.IPv6Lookup,
+		ActiveTransfers:    c.ActiveTransfers,
+		ActiveListenAddr:   c.ActiveListenAddr,
+		DisableEPSV:        c.DisableEPSV,
+		ConnectionsPerHost: c.ConnectionsPerHost,
 	}
 	if len(c.ServerLocation) > 0 {
 		retval.ServerLocation, err = time.LoadLocation(c.ServerLocation)
@@ -55,6 +61,9 @@ type FTPBackend struct {
 	client                *goftp.Client
 	configTemplate        goftp.Config
 	configuredHost        string
+
+	featuresMutex  sync.Mutex
+	serverFeatures map[string]string
 }
 
 func newFTPBackend(params *backends.BackendConstructionParams) (backends.Backend, error) {
@@ -0,0 +1,134 @@
+package ftp
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/kthxat/filament/backends"
+	"github.com/secsy/goftp"
+	"go.uber.org/multierr"
+)
+
+// hashCommands maps checksum algorithms to their names for the HASH command
+// and to the older non-standard commands some servers implement instead.
+var hashCommands = map[backends.HashAlgorithm]struct {
+	Name, Command string
+}{
+	backends.HashSHA256: {"SHA-256", "XSHA256"},
+	backends.HashSHA1:   {"SHA-1", "XSHA1"},
+	backends.HashMD5:    {"MD5", "XMD5"},
+	backends.HashCRC32:  {"CRC32", "XCRC"},
+}
+
+// Hash asks the server for the checksum of a file, using the HASH command or
+// one of the X* commands, whichever the server advertises.
+func (b *FTPBackend) Hash(path string, algo backends.HashAlgorithm) (sum string, err error) {
+	commands, ok := hashCommands[algo]
+	if !ok {
+		return "", backends.ErrHashUnsupported
+	}
+
+	conn, err := b.client.OpenRawConn()
+	if err != nil {
+		return
+	}
+	defer func() {
+		err = multierr.Append(err, conn.Close())
+	}()
+
+	features, err := b.features(conn)
+	if err != nil {
+		return
+	}
+
+	if hashNames, ok := features["HASH"]; ok && containsHashName(hashNames, commands.Name) {
+		code, msg, err := conn.SendCommand("OPTS HASH %s", commands.Name)
+		if err != nil {
+			return "", err
+		}
+		if code == 200 {
+			code, msg, err = conn.SendCommand("HASH %s", path)
+			if err != nil {
+				return "", err
+			}
+			if code != 213 {
+				return "", fmt.Errorf("hashing %s failed: %d %s", path, code, msg)
+			}
+			// The reply looks like "SHA-256 0-49 169cd222... file.txt"
+			fields := strings.Fields(msg)
+			if len(fields) < 3 || !isHex(fields[2]) {
+				return "", fmt.Errorf("hashing %s failed: unexpected reply %q", path, msg)
+			}
+			return strings.ToLower(fields[2]), nil
+		}
+	}
+
+	if _, ok := features[commands.Command]; ok {
+		code, msg, err := conn.SendCommand("%s %s", commands.Command, path)
+		if err != nil {
+			return "", err
+		}
+		if code/100 != 2 {
+			return "", fmt.Errorf("hashing %s failed: %d %s", path, code, msg)
+		}
+		for _, field := range strings.Fields(msg) {
+			if isHex(field) {
+				sum = strings.ToLower(field)
+				if algo == backends.HashCRC32 && len(sum) < 8 {
+					// Some servers drop leading zeros
+					sum = strings.Repeat("0", 8-len(sum)) + sum
+				}
+				return sum, nil
+			}
+		}
+		return "", fmt.Errorf("hashing %s failed: unexpected reply %q", path, msg)
+	}
+
+	return "", backends.ErrHashUnsupported
+}
+
+// features returns the features the server advertises in reply to FEAT,
+// mapped to their parameters. The reply is only requested once.
+func (b *FTPBackend) features(conn goftp.RawConn) (map[string]string, error) {
+	b.featuresMutex.Lock()
+	defer b.featuresMutex.Unlock()
+
+	if b.serverFeatures != nil {
+		return b.serverFeatures, nil
+	}
+
+	code, msg, err := conn.SendCommand("FEAT")
+	if err != nil {
+		return nil, err
+	}
+
+	features := map[string]string{}
+	if code == 211 {
+		for _, line := range strings.Split(msg, "\n") {
+			if !strings.HasPrefix(line, " ") {
+				continue
+			}
+			name, params, _ := strings.Cut(strings.TrimSpace(line), " ")
+			features[strings.ToUpper(name)] = params
+		}
+	}
+	b.serverFeatures = features
+	return features, nil
+}
+
+// containsHashName returns whether the parameters of the HASH feature, e.g.
+// "SHA-256*;SHA-1;MD5", list the given algorithm.
+func containsHashName(hashNames, name string) bool {
+	for _, hashName := range strings.Split(hashNames, ";") {
+		if strings.EqualFold(strings.TrimSuffix(hashName, "*"), name) {
+			return true
+		}
+	}
+	return false
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil || (len(s)%2 == 1 && isHex("0"+s))
+}
@@ -0,0 +1,103 @@
+package ftp
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/kthxat/filament/backends"
+	"github.com/secsy/goftp"
+	"go.uber.org/multierr"
+)
+
+// RetrieveRange resumes a download at the given offset using the REST
+// command on a connection of its own. Servers that do not advertise support
+// for resuming transfers get the whole file requested instead.
+func (b *FTPBackend) RetrieveRange(path string, offset, length int64, w io.Writer) (err error) {
+	if offset == 0 && length < 0 {
+		return b.Retrieve(path, w)
+	}
+
+	conn, err := b.client.OpenRawConn()
+	if err != nil {
+		return
+	}
+	resumed, err := b.resume(conn, path, offset, length, w)
+	// The connection is closed before falling back, which uses connections
+	// of the pool
+	err = multierr.Append(err, conn.Close())
+	if err == nil && !resumed {
+		return backends.RetrieveRangeByDiscarding(b, path, offset, length, w)
+	}
+	return
+}
+
+// resume transfers a range of a file using REST. If the server does not
+// support resuming transfers, false is returned without having transferred
+// anything.
+func (b *FTPBackend) resume(conn goftp.RawConn, path string, offset, length int64, w io.Writer) (resumed bool, err error) {
+	// Support is checked before preparing the data connection, since REST has
+	// to be sent right before the transfer command
+	features, err := b.features(conn)
+	if err != nil {
+		return
+	}
+	if rest, ok := features["REST"]; !ok || !strings.EqualFold(rest, "STREAM") {
+		return
+	}
+
+	if code, msg, err := conn.SendCommand("TYPE I"); err != nil {
+		return false, err
+	} else if code != 200 {
+		return false, fmt.Errorf("switching to binary mode failed: %d %s", code, msg)
+	}
+
+	getDataConn, err := conn.PrepareDataConn()
+	if err != nil {
+		return
+	}
+
+	if code, _, err := conn.SendCommand("REST %d", offset); err != nil || code != 350 {
+		releaseDataConn(getDataConn)
+		return false, err
+	}
+
+	if code, msg, err := conn.SendCommand("RETR %s", path); err != nil {
+		releaseDataConn(getDataConn)
+		return false, err
+	} else if code != 125 && code != 150 {
+		releaseDataConn(getDataConn)
+		return false, fmt.Errorf("retrieving %s failed: %d %s", path, code, msg)
+	}
+
+	dataConn, err := getDataConn()
+	if err != nil {
+		return
+	}
+	resumed = true
+
+	var src io.Reader = dataConn
+	if length >= 0 {
+		src = io.LimitReader(dataConn, length)
+	}
+	_, err = io.Copy(w, src)
+	err = multierr.Append(err, dataConn.Close())
+
+	// The server reports the end of the transfer, or that it was aborted
+	// since we stopped reading early. Either is fine.
+	_, _, readErr := conn.ReadResponse()
+	err = multierr.Append(err, readErr)
+	return
+}
+
+// releaseDataConn closes a prepared data connection that is not going to be
+// used. For active transfers, the listener is only closed once waiting for
+// the server to connect times out, so this happens in the background.
+func releaseDataConn(getDataConn func() (net.Conn, error)) {
+	go func() {
+		if dataConn, err := getDataConn(); err == nil {
+			dataConn.Close()
+		}
+	}()
+}
@@ -0,0 +1,228 @@
+package index
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kthxat/filament/backends"
+)
+
+// Entry is a file as it was found while crawling.
+type Entry struct {
+	// Dir is the directory the file is located in
+	Dir     string
+	Name    string
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+}
+
+// FileInfo returns the entry as an os.FileInfo.
+func (e *Entry) FileInfo() os.FileInfo {
+	return &fileInfo{e}
+}
+
+type fileInfo struct {
+	entry *Entry
+}
+
+func (fi *fileInfo) Name() string       { return fi.entry.Name }
+func (fi *fileInfo) Size() int64        { return fi.entry.Size }
+func (fi *fileInfo) Mode() os.FileMode  { return fi.entry.Mode }
+func (fi *fileInfo) ModTime() time.Time { return fi.entry.ModTime }
+func (fi *fileInfo) IsDir() bool        { return fi.entry.Mode.IsDir() }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+// Tree holds everything found below one crawled directory.
+type Tree struct {
+	Crawled time.Time
+	// Entries are never modified, crawling again replaces them
+	Entries []*Entry
+}
+
+// Index keeps the names, sizes and modification times of all files below a
+// set of directories of a storage in memory, so they can be searched without
+// walking the storage.
+type Index struct {
+	mutex sync.RWMutex
+	trees map[string]*Tree
+}
+
+// NewIndex creates an empty index.
+func NewIndex() *Index {
+	return &Index{
+		trees: map[string]*Tree{},
+	}
+}
+
+func cleanPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// isBelow returns whether p is the same as or located below dir.
+func isBelow(p, dir string) bool {
+	if dir == "/" || p == dir {
+		return true
+	}
+	return strings.HasPrefix(p, dir+"/")
+}
+
+// Crawl walks the given directory of a storage and replaces everything known
+// about it. Directories below it that can't be read are left out. If the
+// directory itself can't be read, the index is left as it was.
+func (idx *Index) Crawl(storage backends.Storage, root string) error {
+	root = cleanPath(root)
+	tree := &Tree{Crawled: time.Now()}
+	err := backends.ReadDirRecursively(storage, root, func(pwd string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if cleanPath(pwd) == root {
+				return err
+			}
+			return nil
+		}
+		tree.Entries = append(tree.Entries, &Entry{
+			Dir:     cleanPath(pwd),
+			Name:    fi.Name(),
+			Size:    fi.Size(),
+			Mode:    fi.Mode(),
+			ModTime: fi.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+	idx.trees[root] = tree
+	return nil
+}
+
+// Crawled returns when the given directory was last crawled. If it never
+// was, the zero time is returned.
+func (idx *Index) Crawled(root string) time.Time {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+	if tree, ok := idx.trees[cleanPath(root)]; ok {
+		return tree.Crawled
+	}
+	return time.Time{}
+}
+
+// Keep forgets about all crawled directories except the given ones.
+func (idx *Index) Keep(roots []string) {
+	keep := map[string]bool{}
+	for _, root := range roots {
+		keep[cleanPath(root)] = true
+	}
+
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+	for root := range idx.trees {
+		if !keep[root] {
+			delete(idx.trees, root)
+		}
+	}
+}
+
+// Search calls cb for every known file below the given directory, like
+// backends.ReadDirRecursivelyLimited does. If the directory is not located
+// below a crawled directory, an error wrapping backends.ErrSearchUnsupported
+// is returned.
+func (idx *Index) Search(p string, depth int, cb filepath.WalkFunc) error {
+	p = cleanPath(p)
+
+	idx.mutex.RLock()
+	var tree *Tree
+	treeRoot := ""
+	for root, t := range idx.trees {
+		// Prefer the most specific tree
+		if isBelow(p, root) && len(root) > len(treeRoot) {
+			tree, treeRoot = t, root
+		}
+	}
+	idx.mutex.RUnlock()
+
+	if tree == nil {
+		return fmt.Errorf("%w: %s is not indexed", backends.ErrSearchUnsupported, p)
+	}
+
+	for _, entry := range tree.Entries {
+		if !isBelow(entry.Dir, p) {
+			continue
+		}
+		if depth > 0 {
+			levels := 1
+			if entry.Dir != p {
+				levels += strings.Count(strings.TrimPrefix(entry.Dir, p), "/")
+				if p == "/" {
+					levels++
+				}
+			}
+			if levels > depth {
+				continue
+			}
+		}
+		if err := cb(entry.Dir, entry.FileInfo(), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load reads an index saved by Save, replacing everything known so far. If
+// the file does not exist, the index is left empty.
+func (idx *Index) Load(file string) error {
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	trees := map[string]*Tree{}
+	if err := gob.NewDecoder(f).Decode(&trees); err != nil {
+		return err
+	}
+
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+	idx.trees = trees
+	return nil
+}
+
+// Save writes the index to the given file.
+func (idx *Index) Save(file string) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash can't leave a broken file
+	f, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+
+	idx.mutex.RLock()
+	err = gob.NewEncoder(f).Encode(idx.trees)
+	idx.mutex.RUnlock()
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), file)
+}
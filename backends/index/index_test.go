@@ -0,0 +1,99 @@
+package index_test
+
+import (
+	"errors"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/kthxat/filament/backends"
+	"github.com/kthxat/filament/backends/index"
+	"github.com/kthxat/filament/internal/storagetest"
+)
+
+var indexTree = map[string]string{
+	"a/1.txt":       "1",
+	"a/b/2.txt":     "22",
+	"a/b/c/3.txt":   "333",
+	"a/b/c/d/4.txt": "4444",
+	"a/e/":          "",
+	"x/y/5.txt":     "55555",
+	"top.txt":       "t",
+}
+
+// collect returns the paths of all files search calls back for.
+func collect(t *testing.T, search func(cb func(string, os.FileInfo, error) error) error) []string {
+	t.Helper()
+
+	visited := []string{}
+	err := search(func(pwd string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		visited = append(visited, path.Join(pwd, fi.Name()))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(visited)
+	return visited
+}
+
+// TestSearchDepth checks that searching the index finds the same files as
+// walking the storage, at every depth.
+func TestSearchDepth(t *testing.T) {
+	storage := storagetest.Local(t, indexTree)
+	idx := index.NewIndex()
+	if err := idx.Crawl(storage, "/"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{"/", "/a", "/a/b", "/a/b/c/d", "/a/e", "/x"} {
+		for depth := 0; depth <= 5; depth++ {
+			got := collect(t, func(cb func(string, os.FileInfo, error) error) error {
+				return idx.Search(p, depth, cb)
+			})
+			want := collect(t, func(cb func(string, os.FileInfo, error) error) error {
+				return backends.ReadDirRecursivelyLimited(storage, p, depth, cb)
+			})
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("search of %s with depth %d found\n%q\nwant\n%q", p, depth, got, want)
+			}
+		}
+	}
+}
+
+func TestSearchRoots(t *testing.T) {
+	storage := storagetest.Local(t, indexTree)
+	idx := index.NewIndex()
+	if err := idx.Crawl(storage, "/a/b"); err != nil {
+		t.Fatal(err)
+	}
+	if idx.Crawled("/a/b/").IsZero() || !idx.Crawled("/a").IsZero() {
+		t.Error("wrong directories are reported as crawled")
+	}
+
+	got := collect(t, func(cb func(string, os.FileInfo, error) error) error {
+		return idx.Search("/a/b/c", 0, cb)
+	})
+	want := []string{"/a/b/c/3.txt", "/a/b/c/d", "/a/b/c/d/4.txt"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("search found\n%q\nwant\n%q", got, want)
+	}
+
+	if err := idx.Search("/a", 0, nil); !errors.Is(err, backends.ErrSearchUnsupported) {
+		t.Errorf("search outside of crawled directories threw %v, want %v",
+			err, backends.ErrSearchUnsupported)
+	}
+
+	// Directories that can't be crawled keep what was found before
+	if err := idx.Crawl(storage, "/missing"); err == nil {
+		t.Error("crawling a missing directory succeeded")
+	}
+	if !idx.Crawled("/missing").IsZero() {
+		t.Error("missing directory is reported as crawled")
+	}
+}
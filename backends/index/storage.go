@@ -0,0 +1,68 @@
+package index
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/kthxat/filament/backends"
+)
+
+// Storage answers searches of another storage from an index. Everything
+// else is passed on to the wrapped storage. As the index is only updated
+// when crawling, search results may be out of date.
+type Storage struct {
+	inner backends.Storage
+	index *Index
+}
+
+// New wraps the given storage, searching it using the given index, which is
+// supposed to be crawled from the same files.
+func New(inner backends.Storage, index *Index) *Storage {
+	return &Storage{
+		inner: inner,
+		index: index,
+	}
+}
+
+func (s *Storage) Close() error {
+	return s.inner.Close()
+}
+
+func (s *Storage) IsLoggedInAs(username string) bool {
+	return s.inner.IsLoggedInAs(username)
+}
+
+func (s *Storage) Stat(p string) (os.FileInfo, error) {
+	return s.inner.Stat(p)
+}
+
+func (s *Storage) ReadDir(p string) ([]os.FileInfo, error) {
+	return s.inner.ReadDir(p)
+}
+
+func (s *Storage) Retrieve(p string, w io.Writer) error {
+	return s.inner.Retrieve(p, w)
+}
+
+func (s *Storage) Authorize(p string, op backends.Operation) error {
+	return backends.Authorize(s.inner, p, op)
+}
+
+func (s *Storage) Invalidate(p string) {
+	backends.Invalidate(s.inner, p)
+}
+
+func (s *Storage) RetrieveRange(p string, offset, length int64, w io.Writer) error {
+	return backends.RetrieveRange(s.inner, p, offset, length, w)
+}
+
+func (s *Storage) Hash(p string, algo backends.HashAlgorithm) (string, error) {
+	return backends.HashWithoutReading(s.inner, p, algo)
+}
+
+// Search reports everything the index knows, including files the account
+// the index was crawled with can see but the user of this storage can not.
+func (s *Storage) Search(p string, depth int, cb filepath.WalkFunc) error {
+	return s.index.Search(p, depth, cb)
+}
@@ -3,6 +3,7 @@ package backends
 import (
 	"io"
 	"os"
+	"path/filepath"
 )
 
 type Backend interface {
@@ -38,3 +39,77 @@ type Storage interface {
 	// application will reuse this instance to access files.
 	IsLoggedInAs(username string) bool
 }
+
+// Operation is something a user may want to do with a path of a storage.
+type Operation string
+
+const (
+	OperationList    Operation = "list"
+	OperationRead    Operation = "read"
+	OperationWrite   Operation = "write"
+	OperationDelete  Operation = "delete"
+	OperationArchive Operation = "archive"
+)
+
+// Authorizer is implemented by storages that restrict which operations may be
+// performed on which paths.
+type Authorizer interface {
+	// Authorize returns nil if the given operation may be performed on the
+	// given path. Otherwise an error wrapping os.ErrPermission or, if the path
+	// is supposed to stay hidden, os.ErrNotExist is returned.
+	Authorize(path string, op Operation) error
+}
+
+// Invalidator is implemented by storages that keep information about files
+// which must be dropped when files change.
+type Invalidator interface {
+	// Invalidate drops everything known about the given path and everything
+	// below it.
+	Invalidate(path string)
+}
+
+// RangeRetriever is implemented by storages that can efficiently retrieve a
+// part of a file.
+type RangeRetriever interface {
+	// RetrieveRange writes length bytes of the file starting at offset to the
+	// destination writer. If length is negative, everything up to the end of
+	// the file is written.
+	RetrieveRange(path string, offset, length int64, dest io.Writer) error
+}
+
+// HashAlgorithm names a checksum algorithm.
+type HashAlgorithm string
+
+const (
+	HashSHA256 HashAlgorithm = "sha256"
+	HashSHA1   HashAlgorithm = "sha1"
+	HashMD5    HashAlgorithm = "md5"
+	HashCRC32  HashAlgorithm = "crc32"
+)
+
+// Hasher is implemented by storages that can compute checksums of files
+// without transferring them, e.g. because the server does it for them.
+type Hasher interface {
+	// Hash returns the hex encoded checksum of the file. If the storage can
+	// not compute checksums using the given algorithm, an error wrapping
+	// ErrHashUnsupported is returned.
+	Hash(path string, algo HashAlgorithm) (string, error)
+}
+
+// Searcher is implemented by storages that can find files below a directory
+// without walking it, e.g. because they keep an index.
+type Searcher interface {
+	// Search calls cb for every file below the given directory, like
+	// ReadDirRecursivelyLimited does. If the storage can not search the
+	// directory, an error wrapping ErrSearchUnsupported is returned before cb
+	// is called. Files may be reported that the user of the storage can not
+	// see or that no longer exist, so callers look up the files they use.
+	Search(path string, depth int, cb filepath.WalkFunc) error
+}
+
+// Filterer is implemented by storages that hide some of the files of the
+// storage they wrap.
+type Filterer interface {
+	// Unfiltered returns the wrapped storage, in which no files are hidden.
+	Unfiltered() Storage
+}
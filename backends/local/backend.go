@@ -0,0 +1,62 @@
+package local
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+
+	"github.com/kthxat/filament/backends"
+)
+
+var errNoRoot = errors.New("no root directory configured")
+
+type LocalBackendConfiguration struct {
+	Root string
+}
+
+func init() {
+	backends.Register(&backends.BackendDescriptor{
+		ID:          "local",
+		DisplayName: "Local directory",
+		Type:        reflect.TypeOf(new(LocalBackend)),
+		New:         newLocalBackend,
+	})
+}
+
+// LocalBackend serves files from a directory on the machine Filament runs on.
+// It does not authenticate users, so it is mostly useful as a mount.
+type LocalBackend struct {
+	root string
+}
+
+func newLocalBackend(params *backends.BackendConstructionParams) (backends.Backend, error) {
+	config := new(LocalBackendConfiguration)
+	if params.Config != nil {
+		err := params.Config.Unmarshal(config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(config.Root) == 0 {
+		return nil, errNoRoot
+	}
+
+	root, err := filepath.Abs(config.Root)
+	if err != nil {
+		return nil, err
+	}
+	// Symbolic links are resolved before checking whether paths are located
+	// inside the root directory, so the root directory has to be as well.
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+
+	return &LocalBackend{
+		root: root,
+	}, nil
+}
+
+func (b *LocalBackend) Close() error {
+	return nil
+}
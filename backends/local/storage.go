@@ -0,0 +1,136 @@
+package local
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// errOutsideRoot is returned for symbolic links that lead outside of the root
+// directory.
+var errOutsideRoot = errors.New("symbolic link leads outside of the root directory")
+
+// resolve maps a slash-separated storage path to a path on the local file
+// system. Cleaning the path as an absolute one first makes sure it can not
+// point outside of the root directory. Symbolic links are resolved as well,
+// and refused if they lead outside of the root directory.
+func (b *LocalBackend) resolve(p string) (string, error) {
+	name, err := filepath.EvalSymlinks(filepath.Join(b.root, filepath.FromSlash(path.Clean("/"+p))))
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(b.root, name); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &os.PathError{Op: "resolve", Path: path.Clean("/" + p), Err: errOutsideRoot}
+	}
+	return name, nil
+}
+
+// hidePath makes sure errors do not reveal where the root directory is
+// located on the local file system.
+func (b *LocalBackend) hidePath(p string, err error) error {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) && strings.HasPrefix(pathErr.Path, b.root) {
+		return &os.PathError{
+			Op:   pathErr.Op,
+			Path: path.Clean("/" + p),
+			Err:  pathErr.Err,
+		}
+	}
+	return err
+}
+
+// open opens a file for reading after resolving its path.
+func (b *LocalBackend) open(p string) (*os.File, error) {
+	name, err := b.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(name)
+}
+
+// namedFileInfo reports a different name than the file it describes, which
+// is the case for the targets of symbolic links.
+type namedFileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi *namedFileInfo) Name() string { return fi.name }
+
+func (b *LocalBackend) IsLoggedInAs(username string) bool {
+	// There is no login, anyone may access this backend.
+	return true
+}
+
+func (b *LocalBackend) Stat(p string) (info os.FileInfo, err error) {
+	defer func() { err = b.hidePath(p, err) }()
+	name, err := b.resolve(p)
+	if err != nil {
+		return
+	}
+	info, err = os.Stat(name)
+	if base := path.Base(path.Clean("/" + p)); err == nil && base != "/" && info.Name() != base {
+		info = &namedFileInfo{info, base}
+	}
+	return
+}
+
+func (b *LocalBackend) ReadDir(p string) (info []os.FileInfo, err error) {
+	defer func() { err = b.hidePath(p, err) }()
+	name, err := b.resolve(p)
+	if err != nil {
+		return
+	}
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		return
+	}
+	info = make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Type()&os.ModeSymlink != 0 {
+			// Leave out links that can not be followed anyway
+			if _, err := b.resolve(path.Join("/", p, entry.Name())); errors.Is(err, errOutsideRoot) {
+				continue
+			}
+		}
+		fi, err := entry.Info()
+		if err != nil {
+			// File vanished in the meantime
+			continue
+		}
+		info = append(info, fi)
+	}
+	return
+}
+
+func (b *LocalBackend) Retrieve(p string, w io.Writer) (err error) {
+	defer func() { err = b.hidePath(p, err) }()
+	f, err := b.open(p)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return
+}
+
+func (b *LocalBackend) RetrieveRange(p string, offset, length int64, w io.Writer) (err error) {
+	defer func() { err = b.hidePath(p, err) }()
+	f, err := b.open(p)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		return
+	}
+	if length < 0 {
+		_, err = io.Copy(w, f)
+	} else {
+		_, err = io.CopyN(w, f, length)
+	}
+	return
+}
@@ -0,0 +1,108 @@
+package local
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestBackend(t *testing.T) (*LocalBackend, string) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	outside := filepath.Join(dir, "outside")
+	for _, name := range []string{filepath.Join(root, "sub"), outside} {
+		if err := os.MkdirAll(name, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, content := range map[string]string{
+		filepath.Join(root, "a.txt"):         "a",
+		filepath.Join(outside, "secret.txt"): "s",
+	} {
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, target := range map[string]string{
+		filepath.Join(root, "inside"):      "a.txt",
+		filepath.Join(root, "sub", "up"):   "../a.txt",
+		filepath.Join(root, "escape"):      "../outside",
+		filepath.Join(root, "sub", "etc"):  "/etc",
+		filepath.Join(root, "sub", "root"): "..",
+	} {
+		if err := os.Symlink(target, name); err != nil {
+			t.Skip(err)
+		}
+	}
+	return &LocalBackend{root: root}, dir
+}
+
+func TestSymlinksInsideRoot(t *testing.T) {
+	b, _ := newTestBackend(t)
+	for _, p := range []string{"/inside", "/sub/up"} {
+		fi, err := b.Stat(p)
+		if err != nil {
+			t.Errorf("Stat(%q): %v", p, err)
+			continue
+		}
+		if want := filepath.Base(p); fi.Name() != want {
+			t.Errorf("Stat(%q).Name() = %q, want %q", p, fi.Name(), want)
+		}
+		var buf bytes.Buffer
+		if err := b.Retrieve(p, &buf); err != nil || buf.String() != "a" {
+			t.Errorf("Retrieve(%q) = %q, %v", p, buf.String(), err)
+		}
+	}
+	if _, err := b.ReadDir("/sub/root/sub"); err != nil {
+		t.Errorf("ReadDir through link to the root: %v", err)
+	}
+}
+
+func TestSymlinksOutsideRoot(t *testing.T) {
+	b, dir := newTestBackend(t)
+	check := func(op, p string, err error) {
+		t.Helper()
+		if !errors.Is(err, errOutsideRoot) {
+			t.Errorf("%s(%q) = %v, want an error", op, p, err)
+		}
+		if err != nil && strings.Contains(err.Error(), dir) {
+			t.Errorf("%s(%q) reveals the root directory: %v", op, p, err)
+		}
+	}
+	for _, p := range []string{"/escape", "/escape/secret.txt", "/sub/etc/passwd"} {
+		_, err := b.Stat(p)
+		check("Stat", p, err)
+		_, err = b.ReadDir(p)
+		check("ReadDir", p, err)
+		check("Retrieve", p, b.Retrieve(p, new(bytes.Buffer)))
+		check("RetrieveRange", p, b.RetrieveRange(p, 0, 1, new(bytes.Buffer)))
+	}
+
+	files, err := b.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range files {
+		if fi.Name() == "escape" {
+			t.Error("ReadDir lists a link leading outside of the root directory")
+		}
+	}
+}
+
+func TestErrorsHideRoot(t *testing.T) {
+	b, dir := newTestBackend(t)
+	_, err := b.Stat("/missing/file")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Stat of a missing file = %v", err)
+	}
+	if strings.Contains(err.Error(), dir) {
+		t.Errorf("error reveals the root directory: %v", err)
+	}
+	var pathErr *os.PathError
+	if !errors.As(err, &pathErr) || pathErr.Path != "/missing/file" {
+		t.Errorf("error path = %v, want /missing/file", err)
+	}
+}
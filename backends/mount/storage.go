@@ -0,0 +1,232 @@
+package mount
+
+import (
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kthxat/filament/backends"
+	"go.uber.org/multierr"
+)
+
+// Mount binds a storage to a location in the virtual file tree.
+type Mount struct {
+	// Path is the location in the virtual file tree, e.g. "/archive".
+	Path string
+
+	// Storage is the backend storage that is made available under Path.
+	Storage backends.Storage
+}
+
+// Storage combines multiple storages into one file tree by routing each
+// request to the mount with the longest matching path prefix. Directories
+// that only exist because mounts are located below them are synthesized.
+type Storage struct {
+	username string
+	mounts   []*Mount
+}
+
+// New creates a mount table for the given user from the given mounts.
+func New(username string, mounts []*Mount) *Storage {
+	sortedMounts := make([]*Mount, len(mounts))
+	for i, m := range mounts {
+		sortedMounts[i] = &Mount{
+			Path:    cleanPath(m.Path),
+			Storage: m.Storage,
+		}
+	}
+
+	// Longest path first so the most specific mount wins
+	sort.SliceStable(sortedMounts, func(i, j int) bool {
+		return len(sortedMounts[i].Path) > len(sortedMounts[j].Path)
+	})
+
+	return &Storage{
+		username: username,
+		mounts:   sortedMounts,
+	}
+}
+
+func cleanPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// isBelow returns whether p is the same as or located below dir.
+func isBelow(p, dir string) bool {
+	if dir == "/" || p == dir {
+		return true
+	}
+	return strings.HasPrefix(p, dir+"/")
+}
+
+// resolve finds the mount responsible for p and translates p into a path
+// relative to that mount's storage.
+func (s *Storage) resolve(p string) (m *Mount, innerPath string, ok bool) {
+	for _, m = range s.mounts {
+		if isBelow(p, m.Path) {
+			innerPath = cleanPath(strings.TrimPrefix(p, m.Path))
+			ok = true
+			return
+		}
+	}
+	m = nil
+	return
+}
+
+// virtualChildren returns the names of the directories that need to be
+// synthesized in dir so that mounts below it can be reached.
+func (s *Storage) virtualChildren(dir string) (names []string) {
+	seen := map[string]bool{}
+	for _, m := range s.mounts {
+		if m.Path == dir || !isBelow(m.Path, dir) {
+			continue
+		}
+		rest := strings.TrimPrefix(strings.TrimPrefix(m.Path, dir), "/")
+		name := strings.SplitN(rest, "/", 2)[0]
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return
+}
+
+func (s *Storage) Close() (err error) {
+	for _, m := range s.mounts {
+		err = multierr.Append(err, m.Storage.Close())
+	}
+	return
+}
+
+func (s *Storage) IsLoggedInAs(username string) bool {
+	return s.username == username
+}
+
+func (s *Storage) Stat(p string) (os.FileInfo, error) {
+	p = cleanPath(p)
+
+	for _, m := range s.mounts {
+		if m.Path == p {
+			// Mount points always show up as directories, no matter what the
+			// backend reports for its root.
+			return &dirInfo{name: path.Base(p)}, nil
+		}
+	}
+
+	isVirtual := len(s.virtualChildren(p)) > 0
+
+	if m, innerPath, ok := s.resolve(p); ok {
+		fi, err := m.Storage.Stat(innerPath)
+		if err == nil || !isVirtual {
+			return fi, err
+		}
+	}
+
+	if isVirtual {
+		return &dirInfo{name: path.Base(p)}, nil
+	}
+
+	return nil, &os.PathError{Op: "stat", Path: p, Err: os.ErrNotExist}
+}
+
+func (s *Storage) ReadDir(p string) (files []os.FileInfo, err error) {
+	p = cleanPath(p)
+
+	virtualNames := s.virtualChildren(p)
+
+	if m, innerPath, ok := s.resolve(p); ok {
+		files, err = m.Storage.ReadDir(innerPath)
+		if err != nil {
+			if len(virtualNames) == 0 {
+				return
+			}
+			// The directory only exists to lead to other mounts
+			files, err = nil, nil
+		}
+	} else if len(virtualNames) == 0 {
+		err = &os.PathError{Op: "readdir", Path: p, Err: os.ErrNotExist}
+		return
+	}
+
+	// Synthesized directories shadow whatever the backend has with the same name
+	shadowed := map[string]bool{}
+	for _, name := range virtualNames {
+		shadowed[name] = true
+	}
+	merged := make([]os.FileInfo, 0, len(files)+len(virtualNames))
+	for _, f := range files {
+		if !shadowed[f.Name()] {
+			merged = append(merged, f)
+		}
+	}
+	for _, name := range virtualNames {
+		merged = append(merged, &dirInfo{name: name})
+	}
+	files = merged
+	return
+}
+
+func (s *Storage) Retrieve(p string, w io.Writer) error {
+	p = cleanPath(p)
+	m, innerPath, ok := s.resolve(p)
+	if !ok {
+		return &os.PathError{Op: "retrieve", Path: p, Err: os.ErrNotExist}
+	}
+	return m.Storage.Retrieve(innerPath, w)
+}
+
+// dirInfo describes a directory that does not exist in any backend.
+type dirInfo struct {
+	name string
+}
+
+func (d *dirInfo) Name() string       { return d.name }
+func (d *dirInfo) Size() int64        { return 0 }
+func (d *dirInfo) Mode() os.FileMode  { return os.ModeDir | 0o555 }
+func (d *dirInfo) ModTime() time.Time { return time.Time{} }
+func (d *dirInfo) IsDir() bool        { return true }
+func (d *dirInfo) Sys() interface{}   { return nil }
+
+func (s *Storage) Authorize(p string, op backends.Operation) error {
+	p = cleanPath(p)
+	m, innerPath, ok := s.resolve(p)
+	if !ok {
+		// Synthesized directories are not restricted by any backend
+		return nil
+	}
+	return backends.Authorize(m.Storage, innerPath, op)
+}
+
+func (s *Storage) Invalidate(p string) {
+	p = cleanPath(p)
+	for _, m := range s.mounts {
+		switch {
+		case isBelow(p, m.Path):
+			backends.Invalidate(m.Storage, cleanPath(strings.TrimPrefix(p, m.Path)))
+		case isBelow(m.Path, p):
+			// The whole mount is located below the invalidated path
+			backends.Invalidate(m.Storage, "/")
+		}
+	}
+}
+
+func (s *Storage) RetrieveRange(p string, offset, length int64, w io.Writer) error {
+	p = cleanPath(p)
+	m, innerPath, ok := s.resolve(p)
+	if !ok {
+		return &os.PathError{Op: "retrieve", Path: p, Err: os.ErrNotExist}
+	}
+	return backends.RetrieveRange(m.Storage, innerPath, offset, length, w)
+}
+
+func (s *Storage) Hash(p string, algo backends.HashAlgorithm) (string, error) {
+	p = cleanPath(p)
+	m, innerPath, ok := s.resolve(p)
+	if !ok {
+		return "", &os.PathError{Op: "hash", Path: p, Err: os.ErrNotExist}
+	}
+	return backends.HashWithoutReading(m.Storage, innerPath, algo)
+}
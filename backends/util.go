@@ -1,10 +1,30 @@
 package backends
 
 import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
 	"path"
 	"path/filepath"
 )
 
+// errRangeComplete stops a retrieval once all wanted bytes were received.
+var errRangeComplete = errors.New("range complete")
+
+// ErrHashUnsupported is returned by Hasher implementations which can not
+// compute checksums using the requested algorithm.
+var ErrHashUnsupported = errors.New("hash algorithm not supported")
+
+// ErrSearchUnsupported is returned by Searcher implementations which can not
+// search the requested directory.
+var ErrSearchUnsupported = errors.New("search not supported")
+
 // ReadDirRecursively recursively walks a given storage from a given path with
 // no restrictions in depth.
 func ReadDirRecursively(storage Storage, relpath string, cb filepath.WalkFunc) error {
@@ -12,7 +32,10 @@ func ReadDirRecursively(storage Storage, relpath string, cb filepath.WalkFunc) e
 }
 
 // ReadDirRecursivelyLimited recursively walks a given storage from a given path.
-// If depth is 0 or less, recursion will be unlimited.
+// If depth is 0 or less, recursion will be unlimited. If a directory can't be
+// read, the callback is called with a nil os.FileInfo and the error; if it
+// returns nil, the directory is skipped. If the callback returns
+// filepath.SkipDir for a directory, its contents are not visited.
 func ReadDirRecursivelyLimited(
 	storage Storage,
 	relpath string,
@@ -25,11 +48,16 @@ func ReadDirRecursivelyLimited(
 		spwd := path.Join(pwd...)
 		files, err := storage.ReadDir(spwd)
 		if err != nil {
-			return cb(spwd, nil, err)
+			if err := cb(spwd, nil, err); err != nil {
+				return err
+			}
 		}
 
 		for _, f := range files {
-			err := cb(spwd, f, err)
+			err := cb(spwd, f, nil)
+			if err == filepath.SkipDir && f.IsDir() {
+				continue
+			}
 			if err != nil {
 				return err
 			}
@@ -38,7 +66,10 @@ func ReadDirRecursivelyLimited(
 					// Can't go deeper since we reached the limit
 					continue
 				}
-				pwds = append(pwds, append(pwd, f.Name()))
+				// Copy the path, appending to it directly would let
+				// siblings share and overwrite the same backing array
+				next := append(append([]string(nil), pwd...), f.Name())
+				pwds = append(pwds, next)
 			}
 		}
 
@@ -54,3 +85,171 @@ func ReadDirRecursivelyLimited(
 
 	return nil
 }
+
+// Authorize checks whether the given operation may be performed on the given
+// path of a storage. Storages that do not implement Authorizer permit
+// everything.
+func Authorize(storage Storage, path string, op Operation) error {
+	if authorizer, ok := storage.(Authorizer); ok {
+		return authorizer.Authorize(path, op)
+	}
+	return nil
+}
+
+// Invalidate tells a storage that the given path and everything below it
+// might have changed. Storages that do not implement Invalidator are left
+// alone.
+func Invalidate(storage Storage, path string) {
+	if invalidator, ok := storage.(Invalidator); ok {
+		invalidator.Invalidate(path)
+	}
+}
+
+// RetrieveRange writes a part of a file of a storage to the destination
+// writer, see RangeRetriever. Storages that do not implement RangeRetriever
+// are handled by RetrieveRangeByDiscarding.
+func RetrieveRange(storage Storage, path string, offset, length int64, dest io.Writer) error {
+	if rangeRetriever, ok := storage.(RangeRetriever); ok {
+		return rangeRetriever.RetrieveRange(path, offset, length, dest)
+	}
+	return RetrieveRangeByDiscarding(storage, path, offset, length, dest)
+}
+
+// RetrieveRangeByDiscarding writes a part of a file of a storage to the
+// destination writer by retrieving the file from its beginning, throwing away
+// everything before the wanted part and aborting the transfer after it.
+func RetrieveRangeByDiscarding(storage Storage, path string, offset, length int64, dest io.Writer) error {
+	w := &rangeWriter{
+		dest:   dest,
+		skip:   offset,
+		remain: length,
+	}
+	err := storage.Retrieve(path, w)
+	if errors.Is(err, errRangeComplete) || (err != nil && w.remain == 0) {
+		// Aborting the transfer on purpose may also surface as some other
+		// error, depending on the backend
+		err = nil
+	}
+	return err
+}
+
+// rangeWriter passes on a part of the written bytes to another writer.
+type rangeWriter struct {
+	dest   io.Writer
+	skip   int64
+	remain int64
+}
+
+func (w *rangeWriter) Write(p []byte) (n int, err error) {
+	n = len(p)
+
+	if w.skip > 0 {
+		if int64(len(p)) <= w.skip {
+			w.skip -= int64(len(p))
+			return
+		}
+		p = p[w.skip:]
+		w.skip = 0
+	}
+
+	if w.remain == 0 {
+		return 0, errRangeComplete
+	}
+	if w.remain > 0 && int64(len(p)) > w.remain {
+		p = p[:w.remain]
+	}
+
+	written, err := w.dest.Write(p)
+	if w.remain > 0 {
+		w.remain -= int64(written)
+	}
+	if err == nil && w.remain == 0 {
+		err = errRangeComplete
+	}
+	return
+}
+
+// HashAlgorithms lists all supported checksum algorithms.
+var HashAlgorithms = []HashAlgorithm{HashSHA256, HashSHA1, HashMD5, HashCRC32}
+
+// NewHash returns a new hash.Hash computing a checksum using the given
+// algorithm.
+func NewHash(algo HashAlgorithm) (hash.Hash, error) {
+	switch algo {
+	case HashSHA256:
+		return sha256.New(), nil
+	case HashSHA1:
+		return sha1.New(), nil
+	case HashMD5:
+		return md5.New(), nil
+	case HashCRC32:
+		return crc32.NewIEEE(), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrHashUnsupported, algo)
+}
+
+// Hash returns the hex encoded checksum of a file of a storage, see Hasher.
+// Storages that do not implement Hasher or do not support the algorithm are
+// handled by HashByReading.
+func Hash(storage Storage, path string, algo HashAlgorithm) (string, error) {
+	if hasher, ok := storage.(Hasher); ok {
+		sum, err := hasher.Hash(path, algo)
+		if !errors.Is(err, ErrHashUnsupported) {
+			return sum, err
+		}
+	}
+	return HashByReading(storage, path, algo)
+}
+
+// HashWithoutReading is like Hash, but returns ErrHashUnsupported instead of
+// reading the file. Storages wrapping other storages use it so that reading,
+// if necessary, happens through all of their layers.
+func HashWithoutReading(storage Storage, path string, algo HashAlgorithm) (string, error) {
+	if hasher, ok := storage.(Hasher); ok {
+		return hasher.Hash(path, algo)
+	}
+	return "", fmt.Errorf("%w: %s", ErrHashUnsupported, algo)
+}
+
+// HashByReading computes the checksum of a file of a storage by retrieving
+// the whole file.
+func HashByReading(storage Storage, path string, algo HashAlgorithm) (string, error) {
+	h, err := NewHash(algo)
+	if err != nil {
+		return "", err
+	}
+	if err := storage.Retrieve(path, h); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Search calls cb for every file below the given directory of a storage, see
+// Searcher. Storages that do not implement Searcher or can not search the
+// directory are walked using ReadDirRecursivelyLimited.
+func Search(storage Storage, path string, depth int, cb filepath.WalkFunc) error {
+	err := SearchWithoutWalking(storage, path, depth, cb)
+	if errors.Is(err, ErrSearchUnsupported) {
+		return ReadDirRecursivelyLimited(storage, path, depth, cb)
+	}
+	return err
+}
+
+// SearchWithoutWalking is like Search, but returns ErrSearchUnsupported
+// instead of walking the storage. Storages wrapping other storages use it so
+// that walking, if necessary, happens through all of their layers.
+func SearchWithoutWalking(storage Storage, path string, depth int, cb filepath.WalkFunc) error {
+	if searcher, ok := storage.(Searcher); ok {
+		return searcher.Search(path, depth, cb)
+	}
+	return ErrSearchUnsupported
+}
+
+// Unfiltered returns a view of a storage in which no files are hidden, see
+// Filterer. Storages that do not implement Filterer are returned as they are.
+func Unfiltered(storage Storage) Storage {
+	if filterer, ok := storage.(Filterer); ok {
+		return filterer.Unfiltered()
+	}
+	return storage
+}
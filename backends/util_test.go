@@ -0,0 +1,123 @@
+package backends_test
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/kthxat/filament/backends"
+	"github.com/kthxat/filament/internal/storagetest"
+)
+
+var walkTree = map[string]string{
+	"a/1.txt":       "1",
+	"a/b/2.txt":     "22",
+	"a/b/c/3.txt":   "333",
+	"a/b/c/d/4.txt": "4444",
+	"a/b/e/f/8.txt": "88888888",
+	"a/b2/5.txt":    "55555",
+	"a/b2/c2/6.txt": "666666",
+	"x/y/z/7.txt":   "7777777",
+	"x/y2/":         "",
+	"x/y3/z3/w3/":   "",
+	"top.txt":       "t",
+}
+
+// walk collects the paths of everything visited below relpath.
+func walk(t *testing.T, storage backends.Storage, relpath string, depth int) []string {
+	t.Helper()
+
+	var visited []string
+	err := backends.ReadDirRecursivelyLimited(storage, relpath, depth, func(pwd string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		visited = append(visited, path.Join(pwd, fi.Name()))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(visited)
+	return visited
+}
+
+func TestReadDirRecursively(t *testing.T) {
+	storage := storagetest.Local(t, walkTree)
+
+	want := []string{
+		"/a", "/a/1.txt", "/a/b", "/a/b/2.txt", "/a/b/c", "/a/b/c/3.txt",
+		"/a/b/c/d", "/a/b/c/d/4.txt", "/a/b/e", "/a/b/e/f", "/a/b/e/f/8.txt", "/a/b2", "/a/b2/5.txt", "/a/b2/c2",
+		"/a/b2/c2/6.txt", "/top.txt", "/x", "/x/y", "/x/y/z", "/x/y/z/7.txt",
+		"/x/y2", "/x/y3", "/x/y3/z3", "/x/y3/z3/w3",
+	}
+	if got := walk(t, storage, "/", 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("walk visited\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestReadDirRecursivelyLimited(t *testing.T) {
+	storage := storagetest.Local(t, walkTree)
+
+	want := []string{
+		"/a/1.txt", "/a/b", "/a/b/2.txt", "/a/b/c", "/a/b/e", "/a/b2", "/a/b2/5.txt",
+		"/a/b2/c2",
+	}
+	if got := walk(t, storage, "/a", 2); !reflect.DeepEqual(got, want) {
+		t.Errorf("walk visited\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestReadDirRecursivelyErrors(t *testing.T) {
+	storage := storagetest.Local(t, walkTree)
+
+	errStop := errors.New("stop")
+	calls := 0
+	err := backends.ReadDirRecursively(storage, "/missing", func(pwd string, fi os.FileInfo, err error) error {
+		calls++
+		if fi != nil || err == nil {
+			t.Errorf("got file %v and error %v, want only an error", fi, err)
+		}
+		return errStop
+	})
+	if err != errStop || calls != 1 {
+		t.Errorf("got error %v after %d calls, want %v after 1 call", err, calls, errStop)
+	}
+
+	// Skipping unreadable directories continues the walk
+	err = backends.ReadDirRecursively(storage, "/missing", func(string, os.FileInfo, error) error {
+		return nil
+	})
+	if err != nil {
+		t.Errorf("got error %v, want nil", err)
+	}
+}
+
+func TestReadDirRecursivelySkipDir(t *testing.T) {
+	storage := storagetest.Local(t, walkTree)
+
+	var visited []string
+	err := backends.ReadDirRecursively(storage, "/a", func(pwd string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		visited = append(visited, path.Join(pwd, fi.Name()))
+		if fi.Name() == "b" {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(visited)
+
+	want := []string{"/a/1.txt", "/a/b", "/a/b2", "/a/b2/5.txt", "/a/b2/c2", "/a/b2/c2/6.txt"}
+	if !reflect.DeepEqual(visited, want) {
+		t.Errorf("walk visited\n%q\nwant\n%q", visited, want)
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"time"
 
+	humanize "github.com/dustin/go-humanize"
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/viper"
 )
@@ -15,6 +17,45 @@ func ReadConfig(appID string) {
 
 	// Set default values
 	viper.SetDefault("HTTP.ListenAddress", ":8080")
+	viper.SetDefault("Anonymous.Username", "anonymous")
+	viper.SetDefault("Anonymous.Password", "anonymous")
+	viper.SetDefault("Shares.DefaultLifetime", 7*24*time.Hour)
+	viper.SetDefault("Shares.MaxLifetime", 30*24*time.Hour)
+	viper.SetDefault("Cache.TTL", 30*time.Second)
+	viper.SetDefault("Cache.MaxEntries", 10000)
+	viper.SetDefault("Cache.Scope", CacheScopeSession)
+	viper.SetDefault("Archive.Prefetch", 4)
+	viper.SetDefault("Archive.PrefetchMemory", "64 MB")
+	viper.SetDefault("Archive.MaxSize", "10 GB")
+	viper.SetDefault("Archive.MaxFiles", 100000)
+	viper.SetDefault("Archive.MaxDepth", 32)
+	viper.SetDefault("Search.MaxDepth", 16)
+	viper.SetDefault("Search.MaxResults", 1000)
+	viper.SetDefault("Search.Timeout", 30*time.Second)
+	viper.SetDefault("DirectorySize.CacheTTL", time.Hour)
+	viper.SetDefault("Preview.MaxTextSize", "1 MB")
+	viper.SetDefault("Theme.ColorScheme", "light dark")
+	viper.SetDefault("Theme.DateFormat", "2006-01-02 15:04")
+	viper.SetDefault("Readme.Names", []string{"README.md", "README.txt", ".message"})
+	viper.SetDefault("Readme.MaxSize", "64 kB")
+	viper.SetDefault("Readme.Position", ReadmeBelow)
+	viper.SetDefault("Thumbnail.Size", 256)
+	viper.SetDefault("Thumbnail.MaxFileSize", "50 MB")
+	viper.SetDefault("Thumbnail.MaxPixels", 50000000)
+	viper.SetDefault("Thumbnail.CacheSize", "500 MB")
+	viper.SetDefault("Index.Roots", []string{"/"})
+	viper.SetDefault("Index.Interval", time.Hour)
+	viper.SetDefault("ContentCache.MaxSize", "1 GB")
+	viper.SetDefault("ContentCache.Scope", CacheScopeUser)
+	if d, err := os.UserCacheDir(); err == nil {
+		viper.SetDefault("ContentCache.Directory", filepath.Join(d, appID, "files"))
+		viper.SetDefault("Index.File", filepath.Join(d, appID, "index.gob"))
+		viper.SetDefault("Thumbnail.Directory", filepath.Join(d, appID, "thumbnails"))
+	} else {
+		viper.SetDefault("ContentCache.Directory", filepath.Join(os.TempDir(), appID+"-files"))
+		viper.SetDefault("Index.File", filepath.Join(os.TempDir(), appID+"-index.gob"))
+		viper.SetDefault("Thumbnail.Directory", filepath.Join(os.TempDir(), appID+"-thumbnails"))
+	}
 
 	// Set directories to read config from
 	if d := os.Getenv("XDG_CONFIG_HOME"); len(d) > 0 {
@@ -45,6 +86,16 @@ func GetConfig() (c *Config) {
 	return
 }
 
+// ParseSize parses a human-readable amount of bytes like "10 GB". An empty
+// string is parsed as 0.
+func ParseSize(s string) (int64, error) {
+	if len(s) == 0 {
+		return 0, nil
+	}
+	size, err := humanize.ParseBytes(s)
+	return int64(size), err
+}
+
 func GetBackendConfig(backendID string) *viper.Viper {
 	b := viper.Sub("Backends")
 	if b == nil {
@@ -59,9 +110,432 @@ type HTTPConfig struct {
 	// TODO - tls config
 }
 
+// MountConfig describes a backend which is made available at a given path of
+// the file tree presented to users.
+type MountConfig struct {
+	// Path is the location in the file tree, e.g. "/archive".
+	Path string
+
+	// Backend is the ID of the backend to mount.
+	Backend string
+
+	// Username and Password are used to log into the mounted backend. If no
+	// username is given, the credentials of the logged in user are passed on.
+	Username string
+	Password string
+
+	// Config replaces the global configuration of the backend for this mount,
+	// which allows mounting multiple servers using the same backend type.
+	Config map[string]interface{}
+}
+
+// BackendConfig returns the configuration to construct the mounted backend
+// with.
+func (m *MountConfig) BackendConfig() (*viper.Viper, error) {
+	if m.Config == nil {
+		return GetBackendConfig(m.Backend), nil
+	}
+	v := viper.New()
+	if err := v.MergeConfigMap(m.Config); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// GroupConfig defines a named group of users.
+type GroupConfig struct {
+	Name    string
+	Members []string
+}
+
+// Subjects selects users by name or by membership in a group. If neither
+// users nor groups are listed, everyone is selected.
+type Subjects struct {
+	Users  []string
+	Groups []string
+}
+
+// Matches returns whether the given user, which is a member of the given
+// groups, is selected.
+func (s *Subjects) Matches(username string, groups []string) bool {
+	if len(s.Users) == 0 && len(s.Groups) == 0 {
+		return true
+	}
+	for _, user := range s.Users {
+		if user == username {
+			return true
+		}
+	}
+	for _, wantedGroup := range s.Groups {
+		for _, group := range groups {
+			if wantedGroup == group {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RootConfig assigns a root directory to the selected users. The
+// placeholder "{username}" in the path is replaced with the name of the
+// logged in user.
+type RootConfig struct {
+	Subjects `mapstructure:",squash"`
+	Path     string
+}
+
+// AccessRuleConfig allows, denies or hides operations on paths for the
+// selected users. Paths are glob patterns in which "**" matches any number of
+// directories. If no operations are listed, the rule applies to all of them.
+type AccessRuleConfig struct {
+	Subjects   `mapstructure:",squash"`
+	Paths      []string
+	Operations []string
+	Effect     string
+}
+
+// AccessConfig defines which user may do what with which path. Rules are
+// checked in order, the first matching rule decides. If no rule matches, the
+// default effect applies, which is to allow the operation unless configured
+// otherwise.
+type AccessConfig struct {
+	Default string
+	Rules   []*AccessRuleConfig
+}
+
+// HideConfig hides files matching patterns from listings, archives and
+// searches, as if they did not exist. Patterns without a slash are matched
+// against the names of files and directories, e.g. ".DS_Store" or ".*".
+// Other patterns are matched against whole paths, using the same syntax as
+// access rules.
+type HideConfig struct {
+	// Patterns are hidden from everyone.
+	Patterns []string
+
+	// Rules hide additional patterns from the selected users.
+	Rules []*HideRuleConfig
+
+	// AllowShowing lets users who logged in choose to see hidden files.
+	// Anonymous visitors and share links never see them.
+	AllowShowing bool
+}
+
+// HideRuleConfig hides files matching patterns from the selected users.
+type HideRuleConfig struct {
+	Subjects `mapstructure:",squash"`
+	Patterns []string
+}
+
+// AnonymousConfig enables read-only access for visitors who did not log in.
+// They share one session which logs into the backends with the configured
+// credentials.
+type AnonymousConfig struct {
+	Enabled bool
+
+	// Username and Password are used to log into the backends. They default to
+	// the "anonymous" login known from public FTP servers. Visitors logging in
+	// with Username get the anonymous session, or are refused if anonymous
+	// access is disabled.
+	Username string
+	Password string
+
+	// Paths restricts anonymous visitors to paths matching any of these
+	// patterns, using the same syntax as access rules. If empty, the whole
+	// file tree is accessible.
+	Paths []string
+}
+
+// SharesConfig controls links that give people without an account access to
+// single files or folders.
+type SharesConfig struct {
+	Enabled bool
+
+	// Secret is used to sign share links and to encrypt the credentials
+	// stored with them. If empty, a random secret is generated on startup,
+	// which invalidates all existing links on restart.
+	Secret string
+
+	// StorePath is the file shares are saved to. If empty, shares are only
+	// kept in memory.
+	StorePath string
+
+	DefaultLifetime time.Duration
+	MaxLifetime     time.Duration
+}
+
+const (
+	// CacheScopeSession gives each session a cache of its own.
+	CacheScopeSession = "session"
+	// CacheScopeUser gives each user a cache of their own.
+	CacheScopeUser = "user"
+	// CacheScopeShared lets all sessions of the same backend share a cache.
+	// Only use this if the backend shows the same files to every user.
+	CacheScopeShared = "shared"
+)
+
+// CacheConfig controls caching of file information retrieved from backends.
+type CacheConfig struct {
+	Enabled bool
+
+	// TTL is how long information is reused before asking the backend again.
+	TTL time.Duration
+
+	// MaxEntries limits how many files and directory listings are kept per
+	// cache.
+	MaxEntries int
+
+	// Scope is either CacheScopeSession or CacheScopeShared.
+	Scope string
+}
+
+// ContentCacheConfig controls keeping the contents of downloaded files on
+// local disk.
+type ContentCacheConfig struct {
+	Enabled   bool
+	Directory string
+
+	// MaxSize limits the disk space taken by cached files, e.g. "10 GB".
+	MaxSize string
+
+	// MaxFileSize keeps files larger than this out of the cache. If empty,
+	// any file fitting into the cache is cached.
+	MaxFileSize string
+
+	// Scope is either CacheScopeUser or CacheScopeShared.
+	Scope string
+}
+
+// ArchiveLimitConfig limits what may be downloaded as a single archive. A
+// size of "" and a number of 0 mean no limit.
+type ArchiveLimitConfig struct {
+	// MaxSize limits the total size of the archived files, e.g. "10 GB".
+	MaxSize string
+
+	// MaxFiles limits the number of archived files and directories.
+	MaxFiles int
+
+	// MaxDepth limits how deeply nested the archived files may be.
+	MaxDepth int
+}
+
+// ArchiveLimitOverrideConfig replaces the archive limits for the selected
+// users.
+type ArchiveLimitOverrideConfig struct {
+	Subjects           `mapstructure:",squash"`
+	ArchiveLimitConfig `mapstructure:",squash"`
+}
+
+// ArchiveConfig controls how archives of directories are written.
+type ArchiveConfig struct {
+	// The limits apply to everyone not selected by any of the overrides.
+	ArchiveLimitConfig `mapstructure:",squash"`
+
+	// Overrides are checked in order, the first matching one wins.
+	Overrides []*ArchiveLimitOverrideConfig
+
+	// Prefetch is how many of the following files are retrieved in parallel
+	// while a file is written to an archive. If 0, files are retrieved one
+	// after another.
+	Prefetch int
+
+	// PrefetchMemory limits the memory taken by prefetched files of a single
+	// archive, e.g. "64 MB". Larger files are not prefetched.
+	PrefetchMemory string
+}
+
+// SearchConfig limits searches walking the file tree.
+type SearchConfig struct {
+	// MaxDepth limits how many directory levels below the directory being
+	// searched are visited. If 0, there is no limit.
+	MaxDepth int
+
+	// MaxResults stops searches after this many results. If 0, there is no
+	// limit.
+	MaxResults int
+
+	// Timeout stops searches that take longer. If 0, there is no limit.
+	Timeout time.Duration
+}
+
+// DirectorySizeConfig controls calculating the total size of directories.
+type DirectorySizeConfig struct {
+	// CacheTTL is how long calculated sizes keep being shown in listings. If
+	// 0, sizes are only shown right after calculating them.
+	CacheTTL time.Duration
+}
+
+// PreviewConfig controls the pages showing files in the browser.
+type PreviewConfig struct {
+	// MaxTextSize limits how much of text files and Markdown documents is
+	// shown, e.g. "1 MB".
+	MaxTextSize string
+}
+
+const (
+	// ReadmeAbove shows README files above directory listings.
+	ReadmeAbove = "above"
+	// ReadmeBelow shows README files below directory listings.
+	ReadmeBelow = "below"
+)
+
+// ThemeConfig changes how pages look.
+type ThemeConfig struct {
+	// TemplateDirectory contains templates that replace the embedded ones of
+	// the same name, e.g. "directory.html" or "partials/head.html".
+	// Templates not found there are taken from the embedded ones. Templates
+	// are read once, when they are first used.
+	TemplateDirectory string
+
+	// Title is shown on top of every page and in window titles.
+	Title string
+
+	// Logo is the URL of an image shown on top of every page.
+	Logo string
+
+	// CSS is added to the style sheet of every page.
+	CSS string
+
+	// ColorScheme is "light", "dark" or, to follow the setting of the
+	// browser, "light dark".
+	ColorScheme string
+
+	// DateFormat is the layout dates are shown in, see time.Layout.
+	DateFormat string
+}
+
+// ReadmeConfig controls showing README files in directory listings.
+type ReadmeConfig struct {
+	// Names lists the files shown, in order of preference. Names are
+	// matched regardless of case. If empty, no README files are shown.
+	Names []string
+
+	// MaxSize limits how much of a README file is shown, e.g. "64 kB".
+	MaxSize string
+
+	// Position is either ReadmeAbove or ReadmeBelow.
+	Position string
+}
+
+// ThumbnailConfig controls the small previews of images shown in galleries.
+type ThumbnailConfig struct {
+	// Size is the width and height in pixels thumbnails fit into.
+	Size int
+
+	// MaxFileSize and MaxPixels keep images that would take too long or too
+	// much memory to decode from being thumbnailed, e.g. "50 MB".
+	MaxFileSize string
+	MaxPixels   int
+
+	// Directory is where thumbnails are cached.
+	Directory string
+
+	// CacheSize limits the disk space taken by cached thumbnails, e.g.
+	// "500 MB".
+	CacheSize string
+}
+
+// IndexConfig enables a background index of the file tree, which answers
+// searches without walking the backends. The index is crawled by a service
+// account; search results are filtered by the access rules and root
+// directories of the searching user and looked up with the user's own
+// account, so they only show files the user can see. Files created since
+// the last crawl are not found until the next one.
+type IndexConfig struct {
+	Enabled bool
+
+	// Backend is the ID of the backend to crawl. If empty, the first
+	// configured backend is used. If mounts are configured, the mounts are
+	// crawled instead.
+	Backend string
+
+	// Username and Password are used to log into the backends.
+	Username string
+	Password string
+
+	// Roots lists the directories to crawl.
+	Roots []string
+
+	// Interval is the time between two crawls.
+	Interval time.Duration
+
+	// File is where the index is saved, so it is available right after a
+	// restart. If empty, the index is only kept in memory.
+	File string
+}
+
 type Config struct {
 	Backends              map[string]map[string]interface{}
 	AuthenticationBackend string
 	StorageBackend        string
+	Mounts                []*MountConfig
+	Groups                []*GroupConfig
+	Roots                 []*RootConfig
+	Access                *AccessConfig
+	Hide                  *HideConfig
+	Anonymous             *AnonymousConfig
+	Shares                *SharesConfig
+	Cache                 *CacheConfig
+	ContentCache          *ContentCacheConfig
+	Archive               *ArchiveConfig
+	Search                *SearchConfig
+	DirectorySize         *DirectorySizeConfig
+	Preview               *PreviewConfig
+	Readme                *ReadmeConfig
+	Thumbnail             *ThumbnailConfig
+	Index                 *IndexConfig
+	Theme                 *ThemeConfig
 	HTTP                  *HTTPConfig
 }
+
+// GroupsOf returns the names of all groups the given user is a member of.
+func (c *Config) GroupsOf(username string) (groups []string) {
+	for _, group := range c.Groups {
+		for _, member := range group.Members {
+			if member == username {
+				groups = append(groups, group.Name)
+				break
+			}
+		}
+	}
+	return
+}
+
+// ArchiveLimitsOf returns the archive limits for the given user.
+func (c *Config) ArchiveLimitsOf(username string) *ArchiveLimitConfig {
+	groups := c.GroupsOf(username)
+	for _, override := range c.Archive.Overrides {
+		if override.Matches(username, groups) {
+			return &override.ArchiveLimitConfig
+		}
+	}
+	return &c.Archive.ArchiveLimitConfig
+}
+
+// HidePatternsOf returns the patterns of files hidden from the given user.
+func (c *Config) HidePatternsOf(username string) []string {
+	if c.Hide == nil {
+		return nil
+	}
+
+	patterns := append([]string{}, c.Hide.Patterns...)
+	groups := c.GroupsOf(username)
+	for _, rule := range c.Hide.Rules {
+		if rule.Matches(username, groups) {
+			patterns = append(patterns, rule.Patterns...)
+		}
+	}
+	return patterns
+}
+
+// RootOf returns the configured root directory template for the given user.
+// The first matching entry wins. If no entry matches, an empty string is
+// returned.
+func (c *Config) RootOf(username string) string {
+	groups := c.GroupsOf(username)
+	for _, root := range c.Roots {
+		if root.Matches(username, groups) {
+			return root.Path
+		}
+	}
+	return ""
+}
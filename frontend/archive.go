@@ -0,0 +1,897 @@
+package frontend
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kthxat/filament/app"
+	"github.com/kthxat/filament/backends"
+	"github.com/kthxat/filament/config"
+	"go.uber.org/multierr"
+)
+
+// archiveWriter writes files into an archive.
+type archiveWriter interface {
+	// Create adds a file to the archive. The returned writer takes the
+	// contents of the file and is only valid until the next call.
+	Create(file fileMapping) (io.Writer, error)
+	Close() error
+}
+
+// archiveOptions are the choices users can make about how archives are
+// written.
+type archiveOptions struct {
+	// Level is the compression level from 0 (none) to 9 (best), or -1 for
+	// the default.
+	Level int
+	// Store disables compression completely, so the size of the archive is
+	// known in advance.
+	Store bool
+	// SkipErrors makes files that can't be read end up in a list of errors
+	// in the archive, instead of failing the whole download.
+	SkipErrors bool
+}
+
+// archiveFormat describes a format archives can be downloaded in.
+type archiveFormat struct {
+	Name        string
+	Extension   string
+	ContentType string
+	NewWriter   func(w io.Writer, options *archiveOptions) archiveWriter
+	// Size returns the exact size of an archive of the given files written
+	// with the given options, or -1 if it depends on their contents.
+	Size func(mappings []fileMapping, options *archiveOptions) (int64, error)
+}
+
+// archiveFormats are the supported archive formats, the first being the
+// default.
+var archiveFormats = []*archiveFormat{
+	{
+		"ZIP", "zip", "application/zip", newZipArchiveWriter, zipArchiveSize,
+	},
+	{
+		"TAR", "tar", "application/x-tar", newTarArchiveWriter, tarArchiveSize,
+	},
+	{
+		"TAR.GZ", "tar.gz", "application/gzip", newTarGZipArchiveWriter,
+		func([]fileMapping, *archiveOptions) (int64, error) { return -1, nil },
+	},
+}
+
+// archiveFormatByExtension returns the archive format with the given file
+// extension, or nil if there is none.
+func archiveFormatByExtension(extension string) *archiveFormat {
+	for _, format := range archiveFormats {
+		if format.Extension == extension {
+			return format
+		}
+	}
+	return nil
+}
+
+// compressedExtensions are the extensions of file types that are compressed
+// already, so compressing them again would only waste time.
+var compressedExtensions = map[string]bool{
+	".7z": true, ".apk": true, ".avi": true, ".bz2": true, ".docx": true,
+	".flac": true, ".gif": true, ".gz": true, ".jar": true, ".jpeg": true,
+	".jpg": true, ".m4a": true, ".m4v": true, ".mkv": true, ".mov": true,
+	".mp3": true, ".mp4": true, ".odt": true, ".ogg": true, ".opus": true,
+	".png": true, ".pptx": true, ".rar": true, ".tgz": true, ".webm": true,
+	".webp": true, ".xlsx": true, ".xz": true, ".zip": true, ".zst": true,
+}
+
+// isCompressed returns whether the file at the given path is of a type that
+// is compressed already.
+func isCompressed(p string) bool {
+	return compressedExtensions[strings.ToLower(path.Ext(p))]
+}
+
+type zipArchiveWriter struct {
+	*zip.Writer
+	options *archiveOptions
+
+	// Stored files are written raw with their checksums calculated here
+	stored     *zip.FileHeader
+	storedCRC  hash.Hash32
+	storedSize *countWriter
+}
+
+func newZipArchiveWriter(w io.Writer, options *archiveOptions) archiveWriter {
+	z := &zipArchiveWriter{
+		Writer:  zip.NewWriter(w),
+		options: options,
+	}
+	if options.Level >= 0 {
+		z.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, options.Level)
+		})
+	}
+	return z
+}
+
+// zipHeader returns the header a file is added to ZIP archives with.
+func zipHeader(file fileMapping, options *archiveOptions) (*zip.FileHeader, error) {
+	fh, err := zip.FileInfoHeader(file.FileInfo)
+	if err != nil {
+		return nil, err
+	}
+	fh.Name = file.Path
+	fh.Modified = file.FileInfo.ModTime()
+
+	if !file.FileInfo.IsDir() {
+		// Whatever the file is to the backend, its contents are archived
+		fh.SetMode(file.FileInfo.Mode().Perm())
+	}
+
+	if file.FileInfo.IsDir() {
+		fh.Name += "/"
+	} else if !options.Store && !isCompressed(file.Path) {
+		fh.Method = zip.Deflate
+	} else {
+		fh.Method = zip.Store
+	}
+
+	if !options.Store {
+		return fh, nil
+	}
+
+	// Without compression the sizes are known in advance, which makes the
+	// archive size predictable, as opposed to leaving it to CreateHeader.
+	fh.SetModTime(file.FileInfo.ModTime())
+	fh.Extra = extendedTimestamp(file.FileInfo.ModTime())
+	if !isASCII(fh.Name) {
+		fh.Flags |= 0x800 // the name is UTF-8 encoded
+	}
+	if file.FileInfo.IsDir() {
+		fh.UncompressedSize64 = 0
+	} else {
+		fh.Flags |= 0x8 // the checksum follows in a data descriptor
+		fh.UncompressedSize64 = uint64(file.FileInfo.Size())
+	}
+	fh.CompressedSize64 = fh.UncompressedSize64
+	return fh, nil
+}
+
+func (z *zipArchiveWriter) Create(file fileMapping) (io.Writer, error) {
+	if err := z.finishStored(); err != nil {
+		return nil, err
+	}
+
+	fh, err := zipHeader(file, z.options)
+	if err != nil {
+		return nil, err
+	}
+
+	if !z.options.Store {
+		return z.CreateHeader(fh)
+	}
+
+	fw, err := z.CreateRaw(fh)
+	if err != nil || file.FileInfo.IsDir() {
+		return fw, err
+	}
+
+	z.stored = fh
+	z.storedCRC = crc32.NewIEEE()
+	z.storedSize = &countWriter{w: fw}
+	return io.MultiWriter(z.storedSize, z.storedCRC), nil
+}
+
+// finishStored completes the stored file written last by filling in its
+// checksum, which the zip writer only writes when the next file is added.
+func (z *zipArchiveWriter) finishStored() error {
+	if z.stored == nil {
+		return nil
+	}
+	fh := z.stored
+	z.stored = nil
+	fh.CRC32 = z.storedCRC.Sum32()
+	if z.storedSize.count != int64(fh.UncompressedSize64) {
+		return fmt.Errorf("size of %s changed from %d to %d bytes while archiving",
+			fh.Name, fh.UncompressedSize64, z.storedSize.count)
+	}
+	return nil
+}
+
+func (z *zipArchiveWriter) Close() error {
+	return multierr.Append(z.finishStored(), z.Writer.Close())
+}
+
+// Sizes of the records in ZIP archives, see the ZIP file format
+// specification
+const (
+	zipLocalHeaderLen      = 30
+	zipCentralHeaderLen    = 46
+	zipDataDescriptorLen   = 16
+	zipDataDescriptor64Len = 24
+	zipEndLen              = 22
+	zip64EndLen            = 56 + 20 // the end record and its locator
+	zipMax16               = 1<<16 - 1
+	zipMax32               = 1<<32 - 1
+)
+
+// zipArchiveSize calculates the size of ZIP archives written without
+// compression the same way archive/zip writes them, including the ZIP64
+// fields needed for large archives.
+func zipArchiveSize(mappings []fileMapping, options *archiveOptions) (int64, error) {
+	if !options.Store {
+		return -1, nil
+	}
+
+	var size, centralSize int64
+	zip64 := false
+	for _, file := range mappings {
+		fh, err := zipHeader(file, options)
+		if err != nil {
+			return 0, err
+		}
+		offset := size
+		nameAndExtra := int64(len(fh.Name) + len(fh.Extra))
+
+		size += zipLocalHeaderLen + nameAndExtra
+		if !file.FileInfo.IsDir() {
+			size += int64(fh.UncompressedSize64)
+			if fh.UncompressedSize64 > zipMax32 {
+				size += zipDataDescriptor64Len
+			} else {
+				size += zipDataDescriptorLen
+			}
+		}
+
+		centralSize += zipCentralHeaderLen + nameAndExtra
+		var extra64 int64
+		if fh.UncompressedSize64 >= zipMax32 {
+			extra64 += 2 * 8 // both the compressed and uncompressed size
+		}
+		if offset >= zipMax32 {
+			extra64 += 8
+		}
+		if extra64 > 0 {
+			centralSize += 4 + extra64
+			zip64 = true
+		}
+	}
+
+	if zip64 || len(mappings) >= zipMax16 || centralSize >= zipMax32 || size >= zipMax32 {
+		size += zip64EndLen
+	}
+	return size + centralSize + zipEndLen, nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// extendedTimestamp returns a zip extra field holding the given modification
+// time, the same as CreateHeader adds.
+func extendedTimestamp(modTime time.Time) []byte {
+	extra := make([]byte, 9)
+	binary.LittleEndian.PutUint16(extra[0:], 0x5455)
+	binary.LittleEndian.PutUint16(extra[2:], 5)
+	extra[4] = 1 // only the modification time is present
+	binary.LittleEndian.PutUint32(extra[5:], uint32(modTime.Unix()))
+	return extra
+}
+
+type tarArchiveWriter struct {
+	*tar.Writer
+}
+
+func newTarArchiveWriter(w io.Writer, _ *archiveOptions) archiveWriter {
+	return &tarArchiveWriter{tar.NewWriter(w)}
+}
+
+// tarHeader returns the header a file is added to TAR archives with.
+func tarHeader(file fileMapping) (*tar.Header, error) {
+	th, err := tar.FileInfoHeader(file.FileInfo, "")
+	if err != nil {
+		return nil, err
+	}
+	th.Name = file.Path
+	if file.FileInfo.IsDir() {
+		th.Name += "/"
+	} else {
+		// Whatever the file is to the backend, its contents are archived
+		th.Typeflag = tar.TypeReg
+		th.Linkname = ""
+		th.Size = file.FileInfo.Size()
+	}
+	return th, nil
+}
+
+func (t *tarArchiveWriter) Create(file fileMapping) (io.Writer, error) {
+	th, err := tarHeader(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.WriteHeader(th); err != nil {
+		return nil, err
+	}
+	return t.Writer, nil
+}
+
+// tarBlockSize is the size of the blocks TAR archives consist of.
+const tarBlockSize = 512
+
+// tarArchiveSize calculates the size of TAR archives from the sizes of the
+// files and their headers, which depend on the names and can take several
+// blocks.
+func tarArchiveSize(mappings []fileMapping, _ *archiveOptions) (int64, error) {
+	// Two empty blocks end the archive
+	size := int64(2 * tarBlockSize)
+	for _, file := range mappings {
+		th, err := tarHeader(file)
+		if err != nil {
+			return 0, err
+		}
+		// The contents are never written, so this writer is left unclosed
+		header := &countWriter{w: io.Discard}
+		if err := tar.NewWriter(header).WriteHeader(th); err != nil {
+			return 0, err
+		}
+		size += header.count
+		size += (th.Size + tarBlockSize - 1) / tarBlockSize * tarBlockSize
+	}
+	return size, nil
+}
+
+type tarGZipArchiveWriter struct {
+	tarArchiveWriter
+	gzip *gzip.Writer
+}
+
+func newTarGZipArchiveWriter(w io.Writer, options *archiveOptions) archiveWriter {
+	level := options.Level
+	if options.Store {
+		level = gzip.NoCompression
+	}
+	gz, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		// The level was validated already
+		panic(err)
+	}
+	return &tarGZipArchiveWriter{
+		tarArchiveWriter: tarArchiveWriter{tar.NewWriter(gz)},
+		gzip:             gz,
+	}
+}
+
+func (t *tarGZipArchiveWriter) Close() error {
+	return multierr.Append(t.tarArchiveWriter.Close(), t.gzip.Close())
+}
+
+// writeZeros writes n zero bytes to w.
+func writeZeros(w io.Writer, n int64) error {
+	zeros := make([]byte, 32*1024)
+	for n > 0 {
+		chunk := int64(len(zeros))
+		if n < chunk {
+			chunk = n
+		}
+		if _, err := w.Write(zeros[:chunk]); err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+// archiveErrorsFileName is the name of the file listing the files that
+// could not be archived.
+const archiveErrorsFileName = "FILAMENT_ERRORS.txt"
+
+// archiveErrors collects the files that could not be archived.
+type archiveErrors struct {
+	bytes.Buffer
+}
+
+func (e *archiveErrors) add(p string, err error) {
+	log.Printf("Skipping %s in archive: %s", p, err)
+
+	// Paths in errors are those of the backend, which users should not see
+	msg := err.Error()
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		msg = strings.Replace(msg, pathErr.Error(), pathErr.Err.Error(), 1)
+	}
+	fmt.Fprintf(e, "%s: %s\n", p, msg)
+}
+
+// FileInfo describes the list of errors as a file in the archive.
+func (e *archiveErrors) FileInfo() os.FileInfo {
+	return &archiveErrorsFileInfo{
+		size:    int64(e.Len()),
+		modTime: time.Now(),
+	}
+}
+
+type archiveErrorsFileInfo struct {
+	size    int64
+	modTime time.Time
+}
+
+func (fi *archiveErrorsFileInfo) Name() string       { return archiveErrorsFileName }
+func (fi *archiveErrorsFileInfo) Size() int64        { return fi.size }
+func (fi *archiveErrorsFileInfo) Mode() os.FileMode  { return 0o444 }
+func (fi *archiveErrorsFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *archiveErrorsFileInfo) IsDir() bool        { return false }
+func (fi *archiveErrorsFileInfo) Sys() interface{}   { return nil }
+
+// parseArchiveOptions reads the archive options from the level, store and
+// onerror parameters of the query or posted form.
+func (r *request) parseArchiveOptions() (*archiveOptions, error) {
+	options := &archiveOptions{Level: -1}
+	if level := r.Request.FormValue("level"); len(level) > 0 {
+		var err error
+		options.Level, err = strconv.Atoi(level)
+		if err != nil || options.Level < 0 || options.Level > 9 {
+			return nil, fmt.Errorf("invalid compression level %q", level)
+		}
+	}
+	if store := r.Request.FormValue("store"); len(store) > 0 {
+		options.Store = store != "0" && store != "false"
+	} else {
+		_, options.Store = r.Request.Form["store"]
+	}
+	switch onError := r.Request.FormValue("onerror"); onError {
+	case "", "abort":
+	case "skip":
+		options.SkipErrors = true
+	default:
+		return nil, fmt.Errorf("invalid error policy %q", onError)
+	}
+	return options, nil
+}
+
+// serveArchive sends a whole directory as an archive.
+func (r *request) serveArchive(relpath string, format *archiveFormat) {
+	fileInfo, err := r.storage.Stat(relpath)
+	if err != nil {
+		r.AbortWithError(storageErrorStatus(err), err)
+		return
+	}
+
+	if !fileInfo.IsDir() {
+		r.AbortWithStatus(http.StatusConflict)
+		return
+	}
+
+	if err := backends.Authorize(r.storage, relpath, backends.OperationArchive); err != nil {
+		r.AbortWithError(storageErrorStatus(err), err)
+		return
+	}
+
+	options, err := r.parseArchiveOptions()
+	if err != nil {
+		r.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	if r.Request.Method == http.MethodHead {
+		// Walking the directory is too expensive just to answer a HEAD request
+		r.Header("content-type", format.ContentType)
+		r.Status(http.StatusOK)
+		return
+	}
+
+	var skipped *archiveErrors
+	if options.SkipErrors {
+		skipped = &archiveErrors{}
+	}
+
+	budget, err := r.archiveBudget()
+	if err != nil {
+		r.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	mappings, err := r.archiveMappings(relpath, relpath, skipped, budget)
+	if err != nil {
+		r.abortWithArchiveError(relpath, err)
+		return
+	}
+
+	r.writeArchive(relpath, mappings, format, options, skipped)
+}
+
+// archiveForm describes the form for downloading selected files as an
+// archive, which is sent to the given link.
+func (r *request) archiveForm(link string) gin.H {
+	formats := []gin.H{}
+	for _, format := range archiveFormats {
+		formats = append(formats, gin.H{
+			"Name":  format.Name,
+			"Value": format.Extension,
+		})
+	}
+	return gin.H{
+		"Link":    link,
+		"Formats": formats,
+		"Name":    r.localize("DownloadSelection", "Download selection"),
+		"Store":   r.localize("ArchiveStore", "Without compression"),
+		"SkipErrors": r.localize("ArchiveSkipErrors",
+			"Skip files that can't be read"),
+	}
+}
+
+// pruneSelection cleans the given paths and drops duplicates as well as paths
+// located inside another one of them, so nothing ends up in an archive twice.
+func pruneSelection(paths []string) []string {
+	cleaned := make([]string, len(paths))
+	for i, p := range paths {
+		cleaned[i] = path.Clean("/" + p)
+	}
+	// Parent directories sort before everything inside them
+	sort.Strings(cleaned)
+
+	pruned := []string{}
+	kept := map[string]bool{}
+	for _, p := range cleaned {
+		inside := false
+		for dir := p; ; dir = path.Dir(dir) {
+			if kept[dir] {
+				inside = true
+				break
+			}
+			if dir == "/" {
+				break
+			}
+		}
+		if !inside {
+			kept[p] = true
+			pruned = append(pruned, p)
+		}
+	}
+	return pruned
+}
+
+// serveArchiveSelection sends the files and directories whose paths
+// relative to the given directory were posted as one archive.
+func (r *request) serveArchiveSelection(relpath string) {
+	if r.Request.Method != http.MethodPost {
+		r.AbortWithStatus(http.StatusMethodNotAllowed)
+		return
+	}
+	if !isSameOrigin(r.Request) {
+		r.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	format := archiveFormatByExtension(r.DefaultPostForm("format", archiveFormats[0].Extension))
+	if format == nil {
+		r.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	options, err := r.parseArchiveOptions()
+	if err != nil {
+		r.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	selection := r.PostFormArray("path")
+	if len(selection) == 0 {
+		r.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if err := backends.Authorize(r.storage, relpath, backends.OperationArchive); err != nil {
+		r.AbortWithError(storageErrorStatus(err), err)
+		return
+	}
+
+	var skipped *archiveErrors
+	if options.SkipErrors {
+		skipped = &archiveErrors{}
+	}
+
+	budget, err := r.archiveBudget()
+	if err != nil {
+		r.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	base := path.Clean("/" + relpath)
+	prefix := strings.TrimSuffix(base, "/") + "/"
+	for i, selected := range selection {
+		selection[i] = path.Join(base, selected)
+		if !strings.HasPrefix(selection[i], prefix) {
+			// Only what is below the directory can be selected
+			r.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+	}
+
+	mappings := []fileMapping{}
+	for _, p := range pruneSelection(selection) {
+		selected := strings.TrimPrefix(p, prefix)
+
+		fi, err := r.storage.Stat(p)
+		if err == nil {
+			op := backends.OperationRead
+			if fi.IsDir() {
+				op = backends.OperationArchive
+			}
+			err = backends.Authorize(r.storage, p, op)
+		}
+		if err != nil {
+			if skipped == nil {
+				r.AbortWithError(storageErrorStatus(err), err)
+				return
+			}
+			skipped.add(selected, err)
+			continue
+		}
+
+		if !fi.IsDir() {
+			file := fileMapping{
+				Path:     selected,
+				FileInfo: fi,
+			}
+			if err := budget.add(file); err != nil {
+				r.abortWithArchiveError(relpath, err)
+				return
+			}
+			mappings = append(mappings, file)
+			continue
+		}
+
+		dirMappings, err := r.archiveMappings(base, p, skipped, budget)
+		if err != nil {
+			r.abortWithArchiveError(relpath, err)
+			return
+		}
+		mappings = append(mappings, dirMappings...)
+	}
+
+	r.writeArchive(base, mappings, format, options, skipped)
+}
+
+// archiveMappings walks the directory dir and maps everything below it the
+// user may download to a path relative to base. If skipped is not nil,
+// directories that can't be read are added to it instead of failing. The
+// walk stops as soon as the budget is exceeded.
+func (r *request) archiveMappings(
+	base, dir string,
+	skipped *archiveErrors,
+	budget *archiveBudget,
+) ([]fileMapping, error) {
+	mappings := []fileMapping{}
+	baseFilepath := filepath.FromSlash(base)
+	relative := func(p string) (string, error) {
+		recalculatedPath, err := filepath.Rel(baseFilepath, filepath.FromSlash(p))
+		return filepath.ToSlash(recalculatedPath), err
+	}
+
+	if path.Clean(base) != path.Clean(dir) {
+		fi, err := r.storage.Stat(dir)
+		if err != nil {
+			return nil, err
+		}
+		recalculatedPath, err := relative(dir)
+		if err != nil {
+			return nil, err
+		}
+		file := fileMapping{
+			Path:     recalculatedPath,
+			FileInfo: fi,
+		}
+		if err := budget.add(file); err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, file)
+	}
+	err := backends.ReadDirRecursively(r.storage, dir, func(pwd string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if skipped == nil {
+				return err
+			}
+			recalculatedPath, relErr := relative(pwd)
+			if relErr != nil {
+				return relErr
+			}
+			skipped.add(recalculatedPath+"/", err)
+			return nil
+		}
+		if fi.IsDir() &&
+			backends.Authorize(r.storage, path.Join(pwd, fi.Name()), backends.OperationArchive) != nil {
+			// Leave out directories the user is not allowed to archive
+			return filepath.SkipDir
+		}
+		if !fi.IsDir() &&
+			backends.Authorize(r.storage, path.Join(pwd, fi.Name()), backends.OperationRead) != nil {
+			// Leave out files the user is not allowed to download
+			return nil
+		}
+		recalculatedPath, err := relative(pwd)
+		if err != nil {
+			return err
+		}
+		file := fileMapping{
+			Path:     path.Join(recalculatedPath, fi.Name()),
+			FileInfo: fi,
+		}
+		if err := budget.add(file); err != nil {
+			return err
+		}
+		mappings = append(mappings, file)
+		return nil
+	})
+	return mappings, err
+}
+
+// writeArchive sends the mapped files below the directory relpath as an
+// archive in the given format. If skipped is not nil, files that can't be
+// retrieved are added to it, and it is added to the archive if it is not
+// empty. Otherwise the download fails on the first error.
+func (r *request) writeArchive(
+	relpath string,
+	mappings []fileMapping,
+	format *archiveFormat,
+	options *archiveOptions,
+	skipped *archiveErrors,
+) {
+	sort.SliceStable(mappings, func(i, j int) bool {
+		a := mappings[i]
+		b := mappings[j]
+		// 1. directories first
+		// 2. alphabetical sorting (a to z)
+		if a.FileInfo.IsDir() != b.FileInfo.IsDir() {
+			return a.FileInfo.IsDir()
+		}
+		return naturalCompare(a.Path, b.Path) < 0
+	})
+
+	if r.share != nil {
+		if err := app.CountShareDownload(r.share.ID); err != nil {
+			r.AbortWithError(shareErrorStatus(err), err)
+			return
+		}
+	}
+
+	name := path.Base(path.Clean("/" + relpath))
+	if name == "/" {
+		name = "archive"
+	}
+	r.Header("content-disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": name + "." + format.Extension,
+	}))
+	r.Header("content-type", format.ContentType)
+	if skipped == nil {
+		// Lets browsers show the progress of the download
+		size, err := format.Size(mappings, options)
+		if err != nil {
+			r.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		if size >= 0 {
+			r.Header("content-length", strconv.FormatInt(size, 10))
+		}
+	}
+	r.Writer.WriteHeaderNow()
+
+	archiveConfig := config.GetConfig().Archive
+	prefetchMemory, err := config.ParseSize(archiveConfig.PrefetchMemory)
+	if err != nil {
+		log.Printf("Invalid archive prefetch memory limit: %s", err)
+	}
+	files := newPrefetcher(r.storage, relpath, mappings, archiveConfig.Prefetch, prefetchMemory)
+	defer files.Close()
+
+	z := format.NewWriter(r.Writer, options)
+	for i, file := range mappings {
+		if file.FileInfo.IsDir() {
+			if _, err := z.Create(file); err != nil {
+				r.abortArchive(err)
+				return
+			}
+			continue
+		}
+
+		contents, err := files.Fetch(i)
+		if err != nil {
+			files.Release(i)
+			if skipped == nil {
+				r.abortArchive(err)
+				return
+			}
+			// Nothing has been written about the file yet, leave it out
+			skipped.add(file.Path, err)
+			continue
+		}
+
+		zw, err := z.Create(file)
+		if err != nil {
+			files.Release(i)
+			r.abortArchive(err)
+			return
+		}
+
+		if contents != nil {
+			_, err = contents.WriteTo(zw)
+			files.Release(i)
+			if err != nil {
+				r.abortArchive(err)
+				return
+			}
+			continue
+		}
+
+		cw := &countWriter{w: zw}
+		err = r.storage.Retrieve(path.Join(relpath, file.Path), cw)
+		if err != nil {
+			if skipped == nil {
+				r.abortArchive(err)
+				return
+			}
+			// The file is in the archive already, so it can only be
+			// completed with zeros to keep the archive consistent
+			skipped.add(file.Path, fmt.Errorf("incomplete after %d bytes: %w", cw.count, err))
+			if err := writeZeros(zw, file.FileInfo.Size()-cw.count); err != nil {
+				r.abortArchive(err)
+				return
+			}
+		}
+	}
+
+	if skipped != nil && skipped.Len() > 0 {
+		zw, err := z.Create(fileMapping{
+			Path:     archiveErrorsFileName,
+			FileInfo: skipped.FileInfo(),
+		})
+		if err == nil {
+			_, err = skipped.WriteTo(zw)
+		}
+		if err != nil {
+			r.abortArchive(err)
+			return
+		}
+	}
+
+	if err := z.Close(); err != nil {
+		r.abortArchive(err)
+	}
+}
+
+// abortArchive cuts the connection after a failure while an archive was
+// sent. This way clients notice the download failed, instead of ending up
+// with an archive that looks complete but is not.
+func (r *request) abortArchive(err error) {
+	log.Printf("Aborting archive download of %s: %s", r.Request.URL.Path, err)
+	r.Error(err)
+
+	conn, _, hijackErr := r.Writer.Hijack()
+	if hijackErr != nil {
+		log.Printf("Could not abort archive download: %s", hijackErr)
+		return
+	}
+	if err := conn.Close(); err != nil {
+		log.Printf("Closing connection threw an error: %s", err)
+	}
+}
@@ -0,0 +1,145 @@
+package frontend
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testFileInfo describes a file that only exists in tests.
+type testFileInfo struct {
+	name  string
+	size  int64
+	isDir bool
+}
+
+func (fi *testFileInfo) Name() string { return fi.name }
+func (fi *testFileInfo) Size() int64  { return fi.size }
+func (fi *testFileInfo) Mode() os.FileMode {
+	if fi.isDir {
+		return os.ModeDir | 0o755
+	}
+	return 0o644
+}
+func (fi *testFileInfo) ModTime() time.Time { return time.Date(2020, 2, 29, 12, 34, 56, 789, time.UTC) }
+func (fi *testFileInfo) IsDir() bool        { return fi.isDir }
+func (fi *testFileInfo) Sys() interface{}   { return nil }
+
+// testMapping maps a file of the given size, or a directory if the path ends
+// with a slash.
+func testMapping(p string, size int64) fileMapping {
+	isDir := strings.HasSuffix(p, "/")
+	p = strings.TrimSuffix(p, "/")
+	return fileMapping{
+		Path:     p,
+		FileInfo: &testFileInfo{name: p[strings.LastIndex(p, "/")+1:], size: size, isDir: isDir},
+	}
+}
+
+func TestArchiveSize(t *testing.T) {
+	mappings := []fileMapping{
+		testMapping("docs/", 0),
+		testMapping("docs/a.txt", 6),
+		testMapping("docs/empty", 0),
+		testMapping("docs/block.bin", 512),
+		testMapping("docs/größe.jpg", 1234),
+		testMapping("docs/"+strings.Repeat("long name ", 20)+".txt", 100),
+	}
+
+	for _, test := range []struct {
+		format  *archiveFormat
+		options *archiveOptions
+		fixed   bool
+	}{
+		{archiveFormatByExtension("zip"), &archiveOptions{Level: -1, Store: true}, true},
+		{archiveFormatByExtension("zip"), &archiveOptions{Level: -1}, false},
+		{archiveFormatByExtension("tar"), &archiveOptions{Level: -1}, true},
+		{archiveFormatByExtension("tar.gz"), &archiveOptions{Level: -1, Store: true}, false},
+	} {
+		var buf bytes.Buffer
+		a := test.format.NewWriter(&buf, test.options)
+		for _, file := range mappings {
+			w, err := a.Create(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !file.FileInfo.IsDir() {
+				if err := writeZeros(w, file.FileInfo.Size()); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if err := a.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		size, err := test.format.Size(mappings, test.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := int64(-1)
+		if test.fixed {
+			want = int64(buf.Len())
+		}
+		if size != want {
+			t.Errorf("%s archive with %+v: got size %d, want %d",
+				test.format.Name, *test.options, size, want)
+		}
+	}
+}
+
+func TestArchiveSizeZIP64(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes more than 4 GiB")
+	}
+
+	// The second file starts beyond 4 GiB and the first needs ZIP64 sizes
+	mappings := []fileMapping{
+		testMapping("huge.bin", 1<<32),
+		testMapping("small.txt", 10),
+	}
+	options := &archiveOptions{Level: -1, Store: true}
+	w := &countWriter{w: io.Discard}
+	a := newZipArchiveWriter(w, options)
+	for _, file := range mappings {
+		fw, err := a.Create(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writeZeros(fw, file.FileInfo.Size()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	size, err := zipArchiveSize(mappings, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != w.count {
+		t.Errorf("got size %d, want %d", size, w.count)
+	}
+}
+
+func TestPruneSelection(t *testing.T) {
+	for _, test := range []struct {
+		paths, want []string
+	}{
+		{[]string{}, []string{}},
+		{[]string{"/a", "/b"}, []string{"/a", "/b"}},
+		{[]string{"/a/b", "/a"}, []string{"/a"}},
+		{[]string{"/a", "/a/", "/a/./b/../c"}, []string{"/a"}},
+		{[]string{"/a/b/c", "/a-c", "/a/b"}, []string{"/a-c", "/a/b"}},
+		{[]string{"/ab", "/a/b"}, []string{"/a/b", "/ab"}},
+	} {
+		if got := pruneSelection(test.paths); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("pruneSelection(%q) = %q, want %q", test.paths, got, test.want)
+		}
+	}
+}
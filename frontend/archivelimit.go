@@ -0,0 +1,112 @@
+package frontend
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/gin-gonic/gin"
+	"github.com/kthxat/filament/config"
+)
+
+// archiveLimitError tells that an archive would exceed one of the limits.
+type archiveLimitError struct {
+	// ID and Other are the message explaining the limit to users
+	ID, Other string
+	// Limit is the exceeded limit in human-readable form
+	Limit string
+}
+
+func (e *archiveLimitError) Error() string {
+	return e.Other + " (limit: " + e.Limit + ")"
+}
+
+// archiveBudget keeps track of what has been added to an archive so far and
+// rejects files once a limit is exceeded.
+type archiveBudget struct {
+	maxSize  int64
+	maxFiles int
+	maxDepth int
+
+	size  int64
+	files int
+}
+
+// newArchiveBudget creates a budget from configured archive limits.
+func newArchiveBudget(limits *config.ArchiveLimitConfig) (*archiveBudget, error) {
+	maxSize, err := config.ParseSize(limits.MaxSize)
+	if err != nil {
+		return nil, err
+	}
+	return &archiveBudget{
+		maxSize:  maxSize,
+		maxFiles: limits.MaxFiles,
+		maxDepth: limits.MaxDepth,
+	}, nil
+}
+
+// add accounts for a file that is going to be archived. If that exceeds a
+// limit, an *archiveLimitError is returned.
+func (b *archiveBudget) add(file fileMapping) error {
+	if b.maxDepth > 0 && strings.Count(file.Path, "/")+1 > b.maxDepth {
+		return &archiveLimitError{
+			ID:    "ArchiveTooDeep",
+			Other: "The folders are nested too deeply to be downloaded as one archive.",
+			Limit: strconv.Itoa(b.maxDepth),
+		}
+	}
+
+	b.files++
+	if b.maxFiles > 0 && b.files > b.maxFiles {
+		return &archiveLimitError{
+			ID:    "ArchiveTooManyFiles",
+			Other: "There are too many files to download them as one archive.",
+			Limit: humanize.Comma(int64(b.maxFiles)),
+		}
+	}
+
+	if !file.FileInfo.IsDir() {
+		b.size += file.FileInfo.Size()
+	}
+	if b.maxSize > 0 && b.size > b.maxSize {
+		return &archiveLimitError{
+			ID:    "ArchiveTooLarge",
+			Other: "The files are too large to download them as one archive.",
+			Limit: humanize.Bytes(uint64(b.maxSize)),
+		}
+	}
+
+	return nil
+}
+
+// archiveBudget returns a budget enforcing the archive limits of the user.
+func (r *request) archiveBudget() (*archiveBudget, error) {
+	return newArchiveBudget(config.GetConfig().ArchiveLimitsOf(r.session.Username()))
+}
+
+// abortWithArchiveError responds with an explanation if an archive exceeds
+// a limit, or just with the status matching the error otherwise.
+func (r *request) abortWithArchiveError(relpath string, err error) {
+	var limitErr *archiveLimitError
+	if !errors.As(err, &limitErr) {
+		r.AbortWithError(storageErrorStatus(err), err)
+		return
+	}
+
+	log.Printf("Rejected archive of %s for %s: %s", relpath, r.session.Username(), err)
+
+	r.HTML(http.StatusForbidden, "archive_limit.html", gin.H{
+		"Message": r.localize(limitErr.ID, limitErr.Other),
+		"Limit":   limitErr.Limit,
+		"T": r.localizeAll(map[string]string{
+			"ArchiveRejected":     "Archive not available",
+			"ArchiveLimit":        "Limit",
+			"ArchiveRejectedHint": "Select fewer files or download them one by one.",
+			"BackToDirectory":     "Back to files",
+		}),
+	})
+	r.Abort()
+}
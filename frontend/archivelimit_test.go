@@ -0,0 +1,66 @@
+package frontend
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kthxat/filament/config"
+)
+
+func TestArchiveBudget(t *testing.T) {
+	for _, test := range []struct {
+		limits config.ArchiveLimitConfig
+		files  []fileMapping
+		// exceeded is the ID of the exceeded limit, if any
+		exceeded string
+	}{
+		{
+			limits: config.ArchiveLimitConfig{},
+			files:  []fileMapping{testMapping("a/", 0), testMapping("a/b/c/d", 1<<40)},
+		},
+		{
+			limits: config.ArchiveLimitConfig{MaxSize: "1 kB", MaxFiles: 3, MaxDepth: 2},
+			files:  []fileMapping{testMapping("a/", 0), testMapping("a/b", 600), testMapping("c", 400)},
+		},
+		{
+			limits:   config.ArchiveLimitConfig{MaxSize: "1 kB"},
+			files:    []fileMapping{testMapping("a", 600), testMapping("b", 401)},
+			exceeded: "ArchiveTooLarge",
+		},
+		{
+			// Directories count as files, but not towards the size
+			limits:   config.ArchiveLimitConfig{MaxSize: "1 kB", MaxFiles: 2},
+			files:    []fileMapping{testMapping("a/", 1<<20), testMapping("a/b", 1), testMapping("c", 1)},
+			exceeded: "ArchiveTooManyFiles",
+		},
+		{
+			limits:   config.ArchiveLimitConfig{MaxDepth: 2},
+			files:    []fileMapping{testMapping("a/", 0), testMapping("a/b/", 0), testMapping("a/b/c", 1)},
+			exceeded: "ArchiveTooDeep",
+		},
+	} {
+		budget, err := newArchiveBudget(&test.limits)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exceeded := ""
+		for _, file := range test.files {
+			err := budget.add(file)
+			var limitErr *archiveLimitError
+			if errors.As(err, &limitErr) {
+				exceeded = limitErr.ID
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if exceeded != test.exceeded {
+			t.Errorf("limits %+v: got exceeded limit %q, want %q", test.limits, exceeded, test.exceeded)
+		}
+	}
+
+	if _, err := newArchiveBudget(&config.ArchiveLimitConfig{MaxSize: "lots"}); err == nil {
+		t.Error("invalid size limit accepted")
+	}
+}
@@ -0,0 +1,114 @@
+package frontend
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/kthxat/filament/backends"
+)
+
+// serveChecksum responds with the checksum of a file in the format of
+// sha256sum and friends. The algorithm is chosen via the algo query
+// parameter and defaults to SHA-256.
+func (r *request) serveChecksum(relpath string) {
+	algo := backends.HashAlgorithm(strings.ToLower(r.DefaultQuery("algo", string(backends.HashSHA256))))
+	if _, err := backends.NewHash(algo); err != nil {
+		r.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	fileInfo, err := r.storage.Stat(relpath)
+	if err != nil {
+		r.AbortWithError(storageErrorStatus(err), err)
+		return
+	}
+	if fileInfo.IsDir() {
+		r.AbortWithStatus(http.StatusConflict)
+		return
+	}
+
+	if err := backends.Authorize(r.storage, relpath, backends.OperationRead); err != nil {
+		r.AbortWithError(storageErrorStatus(err), err)
+		return
+	}
+
+	sum, err := backends.Hash(r.storage, relpath, algo)
+	if err != nil {
+		r.AbortWithError(storageErrorStatus(err), err)
+		return
+	}
+
+	r.String(http.StatusOK, "%s  %s\n", sum, path.Base(relpath))
+}
+
+// serveChecksums responds with a SHA256SUMS manifest listing the SHA-256
+// checksums of all files below a directory that the user may read. As the
+// files may need to be read completely, the same permission and limits as for
+// archives apply.
+func (r *request) serveChecksums(relpath string) {
+	fileInfo, err := r.storage.Stat(relpath)
+	if err != nil {
+		r.AbortWithError(storageErrorStatus(err), err)
+		return
+	}
+	if !fileInfo.IsDir() {
+		r.AbortWithStatus(http.StatusConflict)
+		return
+	}
+
+	if err := backends.Authorize(r.storage, relpath, backends.OperationArchive); err != nil {
+		r.AbortWithError(storageErrorStatus(err), err)
+		return
+	}
+
+	if r.Request.Method == http.MethodHead {
+		// Walking the directory is too expensive just to answer a HEAD request
+		r.Header("content-type", "text/plain; charset=utf-8")
+		r.Status(http.StatusOK)
+		return
+	}
+
+	budget, err := r.archiveBudget()
+	if err != nil {
+		r.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	mappings, err := r.archiveMappings(relpath, relpath, nil, budget)
+	if err != nil {
+		r.abortWithArchiveError(relpath, err)
+		return
+	}
+
+	files := []string{}
+	for _, mapping := range mappings {
+		if !mapping.FileInfo.IsDir() {
+			files = append(files, mapping.Path)
+		}
+	}
+	sort.Strings(files)
+
+	r.Header("content-type", "text/plain; charset=utf-8")
+	r.Writer.WriteHeaderNow()
+
+	ctx := r.Request.Context()
+	for _, file := range files {
+		if ctx.Err() != nil {
+			// The client went away
+			return
+		}
+		sum, err := backends.Hash(r.storage, path.Join(relpath, file), backends.HashSHA256)
+		if err != nil {
+			// Too late to tell the client with a status code
+			r.Error(err)
+			return
+		}
+		if _, err := fmt.Fprintf(r.Writer, "%s  %s\n", sum, file); err != nil {
+			r.Error(err)
+			return
+		}
+		r.Writer.Flush()
+	}
+}
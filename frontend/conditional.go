@@ -0,0 +1,79 @@
+package frontend
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// fileETag derives a weak entity tag from the size and modification time of
+// a file, which is all that can be learned about a file without reading it.
+func fileETag(fileInfo os.FileInfo) string {
+	return fmt.Sprintf(`W/"%x-%x"`, fileInfo.ModTime().UnixNano(), fileInfo.Size())
+}
+
+// contentETag derives a weak entity tag from generated content.
+func contentETag(content []byte) string {
+	return fmt.Sprintf(`W/"%x"`, sha256.Sum256(content))
+}
+
+// setValidators sets the headers clients can use to ask whether content has
+// changed since they last received it. A zero modification time is not
+// sent.
+func (r *request) setValidators(etag string, modTime time.Time) {
+	r.Header("etag", etag)
+	if !modTime.IsZero() {
+		r.Header("last-modified", modTime.UTC().Format(http.TimeFormat))
+	}
+}
+
+// isNotModified evaluates If-None-Match and If-Modified-Since headers and
+// returns whether the client already has the current content.
+func (r *request) isNotModified(etag string, modTime time.Time) bool {
+	if ifNoneMatch := r.GetHeader("If-None-Match"); len(ifNoneMatch) > 0 {
+		// If-Modified-Since must be ignored if If-None-Match is given
+		return etagListMatches(ifNoneMatch, etag)
+	}
+
+	if ifModifiedSince := r.GetHeader("If-Modified-Since"); len(ifModifiedSince) > 0 && !modTime.IsZero() {
+		t, err := http.ParseTime(ifModifiedSince)
+		if err != nil {
+			return false
+		}
+		// HTTP dates only have a resolution of seconds
+		return !modTime.Truncate(time.Second).After(t)
+	}
+
+	return false
+}
+
+// ifRangeMatches returns whether a range request may be answered with only
+// the requested range, which requires the If-Range header, if given, to
+// match the current content. Weak entity tags never match.
+func (r *request) ifRangeMatches(etag string, modTime time.Time) bool {
+	ifRange := r.GetHeader("If-Range")
+	if len(ifRange) == 0 {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) {
+		return !strings.HasPrefix(etag, "W/") && ifRange == etag
+	}
+	t, err := http.ParseTime(ifRange)
+	return err == nil && !modTime.IsZero() && modTime.Truncate(time.Second).Equal(t)
+}
+
+// etagListMatches compares the entity tags of an If-None-Match header with
+// the given one, ignoring whether they are weak.
+func etagListMatches(list, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,263 @@
+package frontend
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kthxat/filament/app"
+	"github.com/kthxat/filament/backends"
+	"github.com/kthxat/filament/config"
+)
+
+// breadcrumbs returns a link for every directory on the way to the given
+// directory. Links are relative, so they also work through share links.
+func breadcrumbs(relpath string) []gin.H {
+	segments := strings.Split(strings.Trim(relpath, "/"), "/")
+	if segments[0] == "" {
+		segments = nil
+	}
+
+	crumbs := make([]gin.H, 0, len(segments)+1)
+	crumbs = append(crumbs, gin.H{
+		"Name": "/",
+		"Link": "./" + strings.Repeat("../", len(segments)),
+	})
+	for i, segment := range segments {
+		crumbs = append(crumbs, gin.H{
+			"Name": segment,
+			"Link": "./" + strings.Repeat("../", len(segments)-i-1),
+		})
+	}
+	return crumbs
+}
+
+// serveShowHidden changes whether the user sees files matching the hide
+// patterns and returns to the directory the form was sent from.
+func (r *request) serveShowHidden() {
+	if r.Request.Method != http.MethodPost {
+		r.AbortWithStatus(http.StatusMethodNotAllowed)
+		return
+	}
+	if !isSameOrigin(r.Request) || r.share != nil || !r.session.CanShowHidden() {
+		r.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	r.session.SetShowHidden(r.PostForm(queryHidden) != "0")
+	// Sizes calculated before left out or included hidden files
+	r.session.ForgetDirSizes("/")
+	r.Redirect(http.StatusSeeOther, strings.TrimSuffix(r.Request.URL.EscapedPath(), relPathHidden))
+}
+
+func (r *request) serveDirectory(relpath string) {
+	if !strings.HasSuffix(relpath, "/") {
+		r.Redirect(http.StatusTemporaryRedirect, r.Request.URL.EscapedPath()+"/")
+		return
+	}
+	if _, ok := r.GetQuery(queryRefresh); ok {
+		backends.Invalidate(r.storage, relpath)
+		if r.canCacheDirSizes() {
+			r.session.ForgetDirSizes(relpath)
+		}
+		r.Redirect(http.StatusSeeOther, r.Request.URL.EscapedPath())
+		return
+	}
+
+	files, err := r.storage.ReadDir(relpath)
+	if err != nil {
+		r.AbortWithError(storageErrorStatus(err), err)
+		return
+	}
+
+	var dirSizes map[string]*app.DirSize
+	if _, ok := r.GetQuery(queryDirSize); ok {
+		if dirSizes = r.calculateDirSizes(relpath); dirSizes == nil {
+			return
+		}
+	} else {
+		dirSizes = r.cachedDirSizes(relpath, files)
+	}
+
+	actions := []gin.H{}
+	var archive gin.H
+	canArchive := backends.Authorize(r.storage, relpath, backends.OperationArchive) == nil
+	if canArchive {
+		actions = append(actions, gin.H{
+			"Name": r.localize("DownloadAsArchiveZIP", "Download as ZIP archive"),
+			"Link": relPathArchiveZip,
+		})
+
+		archive = r.archiveForm(relPathArchive)
+	}
+	actions = append(actions, gin.H{
+		"Name": r.localize("GalleryView", "Gallery view"),
+		"Link": "?" + queryView + "=" + viewGallery,
+	})
+	actions = append(actions, gin.H{
+		"Name": r.localize("CalculateSize", "Calculate size"),
+		"Link": "?" + queryDirSize,
+	})
+	if canArchive {
+		actions = append(actions, gin.H{
+			"Name": r.localize("DownloadChecksums", "Download SHA256SUMS"),
+			"Link": relPathChecksums,
+		})
+	}
+	if cacheConfig := config.GetConfig().Cache; cacheConfig != nil && cacheConfig.Enabled {
+		actions = append(actions, gin.H{
+			"Name": r.localize("Refresh", "Refresh"),
+			"Link": "?" + queryRefresh,
+		})
+	}
+	if r.share == nil && r.session.CanShowHidden() {
+		// Changing the setting is sent as a form, so other sites can't
+		// change it by linking to a page
+		if r.session.ShowHidden() {
+			actions = append(actions, gin.H{
+				"Name": r.localize("HideHiddenFiles", "Hide hidden files"),
+				"Form": gin.H{"Action": relPathHidden, "Name": queryHidden, "Value": "0"},
+			})
+		} else {
+			actions = append(actions, gin.H{
+				"Name": r.localize("ShowHiddenFiles", "Show hidden files"),
+				"Form": gin.H{"Action": relPathHidden, "Name": queryHidden, "Value": "1"},
+			})
+		}
+	}
+	if r.canShare() {
+		actions = append(actions, gin.H{
+			"Name": r.localize("ShareDirectory", "Share this folder"),
+			"Link": relPathShare,
+		})
+	}
+
+	order := r.parseListingOrder()
+	sortFiles(files, order)
+
+	if r.Query(queryView) == viewGallery {
+		r.serveGallery(relpath, files)
+		return
+	}
+
+	entries := make([]gin.H, 0, len(files))
+	for _, fi := range files {
+		link := "./" + url.PathEscape(fi.Name())
+		if fi.IsDir() {
+			link += "/"
+		}
+		entry := gin.H{
+			"Link":    link,
+			"Name":    fi.Name(),
+			"IsDir":   fi.IsDir(),
+			"Size":    fi.Size(),
+			"Mode":    fi.Mode().String(),
+			"Type":    fileType(fi),
+			"Icon":    mimeIcon(fileType(fi)),
+			"ModTime": fi.ModTime(),
+		}
+		if fi.IsDir() {
+			entry["Type"] = r.localize("TypeDirectory", "Folder")
+			entry["Icon"] = mimeIcon("inode/directory")
+			if size, ok := dirSizes[path.Join("/", relpath, fi.Name())]; ok {
+				entry["DirSize"] = size
+			}
+		}
+		if !fi.IsDir() {
+			entry["DownloadLink"] = link + "?" + queryDownload + "=1"
+			if previewKind(fi) != "" {
+				entry["Link"] = link + "/" + relPathPreview
+			}
+		}
+		if r.canShare() {
+			if fi.IsDir() {
+				entry["ShareLink"] = link + relPathShare
+			} else {
+				entry["ShareLink"] = link + "/" + relPathShare
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	columns := []gin.H{}
+	for _, column := range []struct{ Key, ID, Other string }{
+		{sortByName, "ColumnName", "Name"},
+		{sortBySize, "ColumnSize", "Size"},
+		{sortByMTime, "ColumnModified", "Modified"},
+		{"", "ColumnPermissions", "Permissions"},
+		{sortByType, "ColumnType", "Type"},
+	} {
+		c := gin.H{"Name": r.localize(column.ID, column.Other)}
+		if len(column.Key) > 0 {
+			columnOrder := order
+			columnOrder.Key = column.Key
+			columnOrder.Descending = order.Key == column.Key && !order.Descending
+			c["Link"] = columnOrder.Query()
+			if order.Key == column.Key {
+				c["Active"] = true
+				c["Descending"] = order.Descending
+			}
+		}
+		columns = append(columns, c)
+	}
+
+	dirsFirstOrder := order
+	dirsFirstOrder.DirsFirst = !order.DirsFirst
+
+	data := gin.H{
+		"Path":        relpath,
+		"Download":    r.localize("Download", "Download"),
+		"Breadcrumbs": breadcrumbs(relpath),
+		"Files":       entries,
+		"Columns":     columns,
+		"DirsFirst": gin.H{
+			"Name":    r.localize("DirectoriesFirst", "Folders first"),
+			"Link":    dirsFirstOrder.Query(),
+			"Enabled": order.DirsFirst,
+		},
+		"Actions": actions,
+		"Archive": archive,
+		"Search": gin.H{
+			"Name": r.localize("Search", "Search"),
+			"Link": relPathSearch,
+		},
+	}
+	if readme := r.readme(relpath, files); readme != nil {
+		data["Readme"] = readme
+	}
+	if size, ok := dirSizes[path.Clean("/"+relpath)]; ok {
+		data["DirSize"] = gin.H{
+			"Size":       size.Size,
+			"Files":      size.Files,
+			"Incomplete": size.Incomplete,
+			"Calculated": size.Calculated,
+			"T": r.localizeAll(map[string]string{
+				"DirSizeTotal":      "Total size",
+				"DirSizeFiles":      "files",
+				"DirSizeCalculated": "calculated",
+				"DirSizeIncomplete": "Some folders could not be read and are not included.",
+			}),
+		}
+	}
+	if path.Base(relpath) != path.Clean(relpath) {
+		data["ParentPath"] = ".."
+	}
+	if r.canShare() {
+		data["Share"] = gin.H{
+			"Name": r.localize("Share", "Share"),
+		}
+		data["Shares"] = gin.H{
+			"Name": r.localize("ManageShares", "Manage shares"),
+			"Link": "/" + relPathShares,
+		}
+	}
+	if r.session.IsAnonymous() {
+		data["Login"] = gin.H{
+			"Name": r.localize("LogIn", "Log in"),
+			"Link": "/?" + queryLogin + "=" + url.QueryEscape(r.Request.URL.EscapedPath()),
+		}
+	}
+	r.serveCachableHTML("directory.html", data)
+}
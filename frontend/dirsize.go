@@ -0,0 +1,124 @@
+package frontend
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/kthxat/filament/app"
+	"github.com/kthxat/filament/backends"
+	"github.com/kthxat/filament/config"
+)
+
+// canCacheDirSizes returns whether directory sizes can be cached in the
+// session. Share links present a different file tree than the session of
+// their owner, so sizes are not cached for them.
+func (r *request) canCacheDirSizes() bool {
+	return r.share == nil
+}
+
+// calculateDirSizes walks the given directory and returns its total size
+// along with the sizes of its subdirectories, keyed by their paths. The walk
+// is limited like searches are, sizes that could not be calculated
+// completely because of that are marked as incomplete.
+func (r *request) calculateDirSizes(relpath string) map[string]*app.DirSize {
+	relpath = path.Clean("/" + relpath)
+	now := time.Now()
+	total := &app.DirSize{Calculated: now}
+	sizes := map[string]*app.DirSize{relpath: total}
+
+	searchConfig := config.GetConfig().Search
+	ctx := r.Request.Context()
+	if searchConfig.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, searchConfig.Timeout)
+		defer cancel()
+	}
+
+	err := backends.ReadDirRecursivelyLimited(r.storage, relpath, searchConfig.MaxDepth, func(pwd string, fi os.FileInfo, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// Everything below a subdirectory counts towards its size, too
+		var child *app.DirSize
+		level := 1
+		if rest := strings.TrimPrefix(path.Clean("/"+pwd), relpath); len(rest) > 0 {
+			rest = strings.TrimPrefix(rest, "/")
+			level += strings.Count(rest, "/") + 1
+			name := strings.SplitN(rest, "/", 2)[0]
+			child = sizes[path.Join(relpath, name)]
+		}
+
+		if err != nil {
+			total.Incomplete = true
+			if child != nil {
+				child.Incomplete = true
+			}
+			return nil
+		}
+
+		if fi.IsDir() {
+			if child == nil {
+				child = &app.DirSize{Calculated: now}
+				sizes[path.Join(relpath, fi.Name())] = child
+			}
+			if level == searchConfig.MaxDepth {
+				// The walk does not go any deeper
+				total.Incomplete = true
+				child.Incomplete = true
+			}
+			return nil
+		}
+		for _, size := range []*app.DirSize{total, child} {
+			if size != nil {
+				size.Size += fi.Size()
+				size.Files++
+			}
+		}
+		return nil
+	})
+	if r.Request.Context().Err() != nil {
+		// The client went away, the sizes are of no use
+		return nil
+	}
+	if err != nil {
+		// What was found until the walk took too long is shown
+		for _, size := range sizes {
+			size.Incomplete = true
+		}
+	}
+
+	if r.canCacheDirSizes() {
+		for p, size := range sizes {
+			r.session.CacheDirSize(p, size)
+		}
+	}
+	return sizes
+}
+
+// cachedDirSizes returns the sizes of the given directory and its
+// subdirectories that were calculated before.
+func (r *request) cachedDirSizes(relpath string, files []os.FileInfo) map[string]*app.DirSize {
+	sizes := map[string]*app.DirSize{}
+	if !r.canCacheDirSizes() {
+		return sizes
+	}
+
+	relpath = path.Clean("/" + relpath)
+	if size := r.session.CachedDirSize(relpath); size != nil {
+		sizes[relpath] = size
+	}
+	for _, fi := range files {
+		if !fi.IsDir() {
+			continue
+		}
+		p := path.Join(relpath, fi.Name())
+		if size := r.session.CachedDirSize(p); size != nil {
+			sizes[p] = size
+		}
+	}
+	return sizes
+}
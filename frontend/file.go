@@ -0,0 +1,96 @@
+package frontend
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/kthxat/filament/backends"
+)
+
+func (r *request) serveFile(relpath string, fileInfo os.FileInfo) {
+	if err := backends.Authorize(r.storage, relpath, backends.OperationRead); err != nil {
+		r.AbortWithError(storageErrorStatus(err), err)
+		return
+	}
+
+	etag := fileETag(fileInfo)
+	r.setValidators(etag, fileInfo.ModTime())
+	if r.isNotModified(etag, fileInfo.ModTime()) {
+		r.Status(http.StatusNotModified)
+		return
+	}
+
+	byteRange, isRange, err := parseRange(r.GetHeader("Range"), fileInfo.Size())
+	if isRange && !r.ifRangeMatches(etag, fileInfo.ModTime()) {
+		// The client's part of the file is outdated, send all of it
+		isRange, err = false, nil
+	}
+	if err != nil {
+		r.Header("content-range", fmt.Sprintf("bytes */%d", fileInfo.Size()))
+		r.AbortWithStatus(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	if r.Request.Method == http.MethodHead {
+		// Tell everything about the file but its contents
+		isRange = false
+	}
+
+	if r.Request.Method != http.MethodHead {
+		if err := r.countShareDownload(); err != nil {
+			r.AbortWithError(shareErrorStatus(err), err)
+			return
+		}
+	}
+
+	r.Header("accept-ranges", "bytes")
+
+	mimeType := mime.TypeByExtension(path.Ext(relpath))
+	if len(mimeType) > 0 {
+		r.Header("content-type", mimeType)
+	} else {
+		r.Header("content-type", "application/octet-stream")
+	}
+
+	// Files such as HTML documents and SVG images must not run scripts on
+	// the origin of Filament, where they could act on behalf of whoever
+	// opens them. Browsers refuse to show PDFs in a sandbox, their viewers
+	// don't give scripts in them access to the page anyway.
+	r.Header("x-content-type-options", "nosniff")
+	if !strings.HasPrefix(mimeType, "application/pdf") {
+		r.Header("content-security-policy", "sandbox")
+	}
+
+	if _, ok := r.GetQuery(queryDownload); ok {
+		r.Header("content-disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": path.Base(relpath),
+		}))
+	}
+
+	if isRange {
+		r.Header("content-length", fmt.Sprintf("%d", byteRange.length))
+		r.Header("content-range", fmt.Sprintf("bytes %d-%d/%d",
+			byteRange.offset, byteRange.offset+byteRange.length-1, fileInfo.Size()))
+		r.Status(http.StatusPartialContent)
+		r.Writer.WriteHeaderNow()
+
+		err = backends.RetrieveRange(r.storage, relpath, byteRange.offset, byteRange.length, r.Writer)
+	} else {
+		r.Header("content-length", fmt.Sprintf("%d", fileInfo.Size()))
+		r.Writer.WriteHeaderNow()
+
+		if r.Request.Method == http.MethodHead {
+			return
+		}
+		err = r.storage.Retrieve(relpath, r.Writer)
+	}
+	if err != nil {
+		log.Printf("Writing file from storage to HTTP failed: %s",
+			err.Error())
+	}
+}
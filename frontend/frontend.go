@@ -1,46 +1,72 @@
 package frontend
 
 import (
-	"archive/zip"
-	"fmt"
-	"html/template"
-	"log"
-	"mime"
 	"net/http"
 	"os"
-	"path"
-	"path/filepath"
-	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/BurntSushi/toml"
-	rice "github.com/GeertJohan/go.rice"
-	humanize "github.com/dustin/go-humanize"
 	gintemplate "github.com/foolin/gin-template"
-	"github.com/foolin/gin-template/supports/gorice"
 	"github.com/gin-gonic/gin"
 	"github.com/kthxat/filament/app"
 	"github.com/kthxat/filament/backends"
 	"github.com/kthxat/filament/config"
 	"github.com/nicksnyder/go-i18n/v2/i18n"
-	"go.uber.org/multierr"
 	"golang.org/x/text/language"
 )
 
 const (
 	relPathActions         = ".filament"
-	relPathArchiveZip      = relPathActions + "/archive.zip"
-	relPathArchiveTar      = relPathActions + "/archive.tar"
+	relPathArchive         = relPathActions + "/archive"
+	relPathArchiveZip      = relPathArchive + ".zip"
+	relPathArchiveTar      = relPathArchive + ".tar"
 	relPathArchiveTarXZ    = relPathArchiveTar + ".xz"
 	relPathArchiveTarGZip  = relPathArchiveTar + ".gz"
 	relPathArchiveTarBZip2 = relPathArchiveTar + ".bz2"
 	relPathArchiveTar7Zip  = relPathArchiveTar + ".7z"
+	relPathSearch          = relPathActions + "/search"
+	relPathPreview         = relPathActions + "/preview"
+	relPathThumbnail       = relPathActions + "/thumbnail"
+	relPathChecksum        = relPathActions + "/checksum"
+	relPathChecksums       = relPathActions + "/SHA256SUMS"
+	relPathHidden          = relPathActions + "/hidden"
+	relPathShare           = relPathActions + "/share"
+	relPathShares          = relPathActions + "/shares"
+	relPathShareLinks      = relPathActions + "/s"
+)
+
+const (
+	// queryLogin is the query parameter that makes anonymous visitors log in.
+	// Its value is the path to go back to afterwards.
+	queryLogin = "login"
+	// queryRefresh is the query parameter that makes Filament forget cached
+	// information about a directory.
+	queryRefresh = "refresh"
+	// queryDirSize is the query parameter that makes Filament calculate the
+	// total size of a directory.
+	queryDirSize = "size"
+	// queryDownload is the query parameter that makes browsers save files
+	// instead of showing them.
+	queryDownload = "download"
+	// queryHidden is the form field that makes Filament show or, if set to
+	// "0", hide files matching the hide patterns.
+	queryHidden = "hidden"
+	// queryView selects how directories are shown, either as a list or, if
+	// set to viewGallery, as a gallery of images.
+	queryView   = "view"
+	viewGallery = "gallery"
+	// querySort, queryOrder and queryDirsFirst select how directory
+	// listings are sorted.
+	querySort      = "sort"
+	queryOrder     = "order"
+	queryDirsFirst = "dirsfirst"
 )
 
 type FrontendServer struct {
 	httpServer *http.Server
 	i18n       *i18n.Bundle
+	html       *gintemplate.TemplateEngine
 }
 
 type fileMapping struct {
@@ -56,223 +82,28 @@ func NewFrontendServer(config *config.HTTPConfig) *FrontendServer {
 	bundle := i18n.NewBundle(language.English)
 	bundle.RegisterUnmarshalFunc("toml", toml.Unmarshal)
 
+	f := &FrontendServer{
+		i18n: bundle,
+	}
+
 	// Session management
 	authorized := r.Group("/", UsernameBasedSessions(config.AuthenticationRealm))
 
-	// Templates via rice box
-	r.HTMLRender = gorice.NewWithConfig(rice.MustFindBox("templates"), gintemplate.TemplateConfig{
-		Funcs: template.FuncMap{
-			"humanize_bytes": func(bytes int64) string {
-				return humanize.Bytes(uint64(bytes))
-			},
-		},
-	})
+	// Templates via rice box, unless overridden
+	f.html = newTemplateEngine()
+	r.HTMLRender = f.html
 
 	// Routes
-	authorized.GET("/*path", func(c *gin.Context) {
-		session := app.GetSessionByID(c.GetString(gin.AuthUserKey))
-		if session == nil {
-			c.AbortWithStatus(http.StatusForbidden)
-			return
-		}
-
-		session.Increment()
-		defer session.Decrement()
-
-		lang := session.Language()
-		accept := c.GetHeader("Accept-Language")
-		localizer := i18n.NewLocalizer(bundle, lang, accept)
-
-		relpath := c.Param("path")
-
-		switch {
-		case strings.HasSuffix(relpath, "/"+relPathArchiveZip):
-			relpath = strings.TrimSuffix(relpath, relPathArchiveZip)
-
-			fileInfo, err := session.Storage().Stat(relpath)
-			if err != nil {
-				c.AbortWithError(http.StatusInternalServerError, err)
-				return
-			}
-
-			if !fileInfo.IsDir() {
-				c.AbortWithStatus(http.StatusConflict)
-				return
-			}
-
-			mappings := []fileMapping{}
-			relpathFilepath := filepath.FromSlash(relpath)
-			err = backends.ReadDirRecursively(session.Storage(), relpath, func(pwd string, fi os.FileInfo, err error) error {
-				log.Printf("I % -99s %s", path.Join(pwd, fi.Name()), fi.ModTime())
-				if err != nil {
-					return err
-				}
-				pwdFilepath := filepath.FromSlash(pwd)
-				recalculatedPath, err := filepath.Rel(relpathFilepath, pwdFilepath)
-				if err != nil {
-					return err
-				}
-				mappings = append(mappings, fileMapping{
-					Path: path.Join(
-						filepath.ToSlash(recalculatedPath),
-						fi.Name()),
-					FileInfo: fi,
-				})
-				return nil
-			})
-			if err != nil {
-				c.AbortWithError(http.StatusInternalServerError, err)
-				return
-			}
-
-			sort.Slice(mappings, func(i, j int) bool {
-				a := mappings[i]
-				b := mappings[j]
-				// 1. directories first
-				// 2. alphabetical sorting (a to z)
-				return a.FileInfo.IsDir() &&
-					strings.Compare(a.Path, b.Path) == -1
-			})
-
-			c.Header("content-type", "application/zip")
-			c.Writer.WriteHeaderNow()
-
-			z := zip.NewWriter(c.Writer)
-			// dotFH := &zip.FileHeader{
-			// 	Name:               "",
-			// 	UncompressedSize64: uint64(fileInfo.Size()),
-			// }
-			// dotFH.SetModTime(fileInfo.ModTime())
-			// dotFH.SetMode(fileInfo.Mode())
-			// z.CreateHeader(dotFH)
-			for _, file := range mappings {
-				fh, err := zip.FileInfoHeader(file.FileInfo)
-				if err != nil {
-					c.Error(err)
-					return
-				}
-				fh.Name = file.Path
-				fh.Modified = file.FileInfo.ModTime()
-
-				if !file.FileInfo.IsDir() {
-					fh.Method = zip.Deflate
-				}
-
-				log.Printf("O % -99s %s", fh.Name, fh.Modified)
-
-				zw, err := z.CreateHeader(fh)
-				if file.FileInfo.IsDir() {
-					continue
-				}
-				if err != nil {
-					multierr.Append(err, z.SetComment("Incomplete file"))
-					c.Error(err)
-					return
-				}
-
-				err = session.Storage().Retrieve(path.Join(relpath, file.Path), zw)
-				if err != nil {
-					err = multierr.Append(err, z.SetComment("Incomplete file"))
-					c.Error(err)
-					return
-				}
-			}
-
-			if err := z.Close(); err != nil {
-				c.Error(err)
-			}
-			return
-
-		case
-			strings.HasSuffix(relpath, "/"+relPathArchiveTar),
-			strings.HasSuffix(relpath, "/"+relPathArchiveTarBZip2),
-			strings.HasSuffix(relpath, "/"+relPathArchiveTarGZip),
-			strings.HasSuffix(relpath, "/"+relPathArchiveTarXZ),
-			strings.HasSuffix(relpath, "/"+relPathArchiveTar7Zip):
-			c.AbortWithStatus(http.StatusNotImplemented)
-			return
-		case strings.HasSuffix(relpath, "/.filament/archive.7z"):
-			c.AbortWithStatus(http.StatusNotImplemented)
-			return
-		}
-
-		fileInfo, err := session.Storage().Stat(relpath)
-		if err != nil {
-			c.AbortWithError(http.StatusInternalServerError, err)
-			return
-		}
-
-		if fileInfo.IsDir() {
-			if !strings.HasSuffix(relpath, "/") {
-				c.Redirect(http.StatusTemporaryRedirect, relpath+"/")
-				return
-			}
-			files, err := session.Storage().ReadDir(relpath)
-			if err != nil {
-				c.AbortWithError(http.StatusInternalServerError, err)
-			}
-
-			localizedDownloadAsArchiveZIP, err := localizer.Localize(&i18n.LocalizeConfig{
-				DefaultMessage: &i18n.Message{
-					ID:    "DownloadAsArchiveZIP",
-					Other: "Download as ZIP archive",
-				},
-			})
-			if err != nil {
-				c.AbortWithError(http.StatusInternalServerError, err)
-			}
-
-			data := gin.H{
-				"Path":  relpath,
-				"Files": files,
-				"Actions": []gin.H{
-					{
-						"Name": localizedDownloadAsArchiveZIP,
-						"Link": relPathArchiveZip,
-					},
-				},
-			}
-			if path.Base(relpath) != path.Clean(relpath) {
-				data["ParentPath"] = ".."
-			}
-			sort.Slice(files, func(i, j int) bool {
-				a := files[i]
-				b := files[j]
-				// 1. directories first
-				// 2. alphabetical sorting (a to z)
-				return a.IsDir() &&
-					strings.Compare(a.Name(), b.Name()) == -1
-			})
-			// c.JSON(http.StatusOK, files)
-			c.HTML(http.StatusOK, "directory.html", data)
-			return
-		}
-
-		c.Header("content-length", fmt.Sprintf("%d", fileInfo.Size()))
-
-		if mimeType := mime.TypeByExtension(path.Ext(relpath)); len(mimeType) > 0 {
-			c.Header("content-type", mimeType)
-		} else {
-			c.Header("content-type", "application/octet-stream")
-		}
-
-		c.Writer.WriteHeaderNow()
-
-		err = session.Storage().Retrieve(relpath, c.Writer)
-		if err != nil {
-			log.Printf("Writing file from storage to HTTP failed: %s",
-				err.Error())
-		}
-	})
+	authorized.GET("/*path", f.handle)
+	authorized.HEAD("/*path", f.handle)
+	authorized.POST("/*path", f.handle)
 
 	httpServer := new(http.Server)
 	httpServer.Addr = config.ListenAddress
 	httpServer.Handler = r
 
-	return &FrontendServer{
-		httpServer: httpServer,
-		i18n:       bundle,
-	}
+	f.httpServer = httpServer
+	return f
 }
 
 func (f *FrontendServer) ListenAndServe() error {
@@ -283,16 +114,69 @@ func (f *FrontendServer) Close() error {
 	return f.httpServer.Close()
 }
 
+func (f *FrontendServer) handle(c *gin.Context) {
+	relpath := c.Param("path")
+
+	if strings.HasPrefix(relpath, "/"+relPathShareLinks+"/") {
+		f.handleShareLink(c, strings.TrimPrefix(relpath, "/"+relPathShareLinks+"/"))
+		return
+	}
+
+	session := app.GetSessionByID(c.GetString(gin.AuthUserKey))
+	if session == nil {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	session.Increment()
+	defer session.Decrement()
+
+	if target, wantsLogin := c.GetQuery(queryLogin); wantsLogin {
+		// Only reachable with valid credentials, so there is nothing
+		// left to do but to go back to browsing where the visitor left
+		// off. Logging in from the root directory makes browsers send
+		// the credentials for all other paths, too.
+		if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") ||
+			strings.HasPrefix(target, "/\\") {
+			// Only go back to paths on this server
+			target = relpath
+		}
+		c.Redirect(http.StatusSeeOther, target)
+		return
+	}
+
+	storage := session.Storage()
+	if session.ShowHidden() && session.CanShowHidden() {
+		storage = backends.Unfiltered(storage)
+	}
+	r := f.newRequest(c, session, storage)
+
+	if relpath == "/"+relPathShares {
+		r.serveShares()
+		return
+	}
+
+	r.serve(relpath)
+}
+
 func UsernameBasedSessions(realm string) gin.HandlerFunc {
 	if realm == "" {
 		realm = "Authorization Required"
 	}
 	realm = "Basic realm=" + strconv.Quote(realm)
 	return func(c *gin.Context) {
+		if strings.HasPrefix(c.Param("path"), "/"+relPathShareLinks+"/") {
+			// Share links carry their own authorization
+			return
+		}
+
 		var sid string
 		if username, password, ok := parseBasicAuth(c.Request.Header.Get("Authorization")); ok {
 			// Valid Authentication header was passed!
 			sid = app.Authenticate(username, password)
+		} else if _, wantsLogin := c.GetQuery(queryLogin); !wantsLogin {
+			// Visitors who did not ask to log in may get anonymous access
+			sid = app.AuthenticateAnonymous()
 		}
 
 		if len(sid) == 0 {
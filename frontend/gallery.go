@@ -0,0 +1,73 @@
+package frontend
+
+import (
+	"net/url"
+	"os"
+	"path"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serveGallery shows the images of a directory as a grid of thumbnails,
+// followed by its subdirectories and all other files.
+func (r *request) serveGallery(relpath string, files []os.FileInfo) {
+	folders := []gin.H{}
+	images := []gin.H{}
+	others := []gin.H{}
+	for _, fi := range files {
+		link := "./" + url.PathEscape(fi.Name())
+		switch {
+		case fi.IsDir():
+			folders = append(folders, gin.H{
+				"Name": fi.Name(),
+				"Link": link + "/?" + queryView + "=" + viewGallery,
+			})
+
+		case hasThumbnail(fi):
+			images = append(images, gin.H{
+				"Name":      fi.Name(),
+				"Link":      link,
+				"Thumbnail": link + "/" + relPathThumbnail,
+			})
+
+		default:
+			other := gin.H{
+				"Name": fi.Name(),
+				"Link": link,
+				"Size": fi.Size(),
+			}
+			if previewKind(fi) != "" {
+				other["Link"] = link + "/" + relPathPreview
+			}
+			others = append(others, other)
+		}
+	}
+
+	// Stay in the gallery when moving up
+	crumbs := breadcrumbs(relpath)
+	for _, crumb := range crumbs {
+		crumb["Link"] = crumb["Link"].(string) + "?" + queryView + "=" + viewGallery
+	}
+
+	data := gin.H{
+		"Path":        relpath,
+		"Breadcrumbs": crumbs,
+		"Folders":     folders,
+		"Images":      images,
+		"Others":      others,
+		"ListView": gin.H{
+			"Name": r.localize("ListView", "List view"),
+			"Link": "./",
+		},
+		"T": r.localizeAll(map[string]string{
+			"GalleryNoImages": "There are no images in this folder.",
+			"GalleryPrevious": "Previous",
+			"GalleryNext":     "Next",
+			"GalleryClose":    "Close",
+		}),
+	}
+	if path.Base(relpath) != path.Clean(relpath) {
+		data["ParentPath"] = "../?" + queryView + "=" + viewGallery
+	}
+	r.serveCachableHTML("gallery.html", data)
+}
@@ -0,0 +1,163 @@
+package frontend
+
+import (
+	"bytes"
+	"path"
+	"sync"
+
+	"github.com/kthxat/filament/backends"
+)
+
+// prefetchResult is a prefetched file, or the error that occurred while
+// retrieving it.
+type prefetchResult struct {
+	contents *bytes.Buffer
+	err      error
+}
+
+// prefetcher retrieves the files of an archive ahead of time, in parallel,
+// while the archive is being written. Files are kept in memory until they
+// are written, so the number of files and the memory taken by them are
+// limited. Files larger than the memory limit are retrieved when they are
+// written.
+type prefetcher struct {
+	storage  backends.Storage
+	relpath  string
+	mappings []fileMapping
+	results  []chan prefetchResult
+	enabled  bool
+
+	// Files are prefetched while a slot and enough memory are available
+	mutex     sync.Mutex
+	cond      *sync.Cond
+	slots     int
+	memory    int64
+	maxMemory int64
+	closed    bool
+}
+
+// newPrefetcher starts prefetching up to the given number of files at once
+// from below relpath, taking up to maxMemory bytes. If count is 0 or less,
+// nothing is prefetched.
+func newPrefetcher(
+	storage backends.Storage,
+	relpath string,
+	mappings []fileMapping,
+	count int,
+	maxMemory int64,
+) *prefetcher {
+	p := &prefetcher{
+		storage:   storage,
+		relpath:   relpath,
+		mappings:  mappings,
+		results:   make([]chan prefetchResult, len(mappings)),
+		enabled:   count > 0 && maxMemory > 0,
+		slots:     count,
+		memory:    maxMemory,
+		maxMemory: maxMemory,
+	}
+	p.cond = sync.NewCond(&p.mutex)
+
+	if !p.enabled {
+		return p
+	}
+
+	jobs := make(chan int)
+	for i := 0; i < count; i++ {
+		go p.work(jobs)
+	}
+	go p.dispatch(jobs)
+	return p
+}
+
+// prefetchable returns whether the file with the given index is supposed to
+// be prefetched.
+func (p *prefetcher) prefetchable(i int) bool {
+	fi := p.mappings[i].FileInfo
+	return !fi.IsDir() && fi.Size() <= p.maxMemory
+}
+
+// dispatch hands out the files to prefetch to the workers in order, waiting
+// for slots and memory to become available.
+func (p *prefetcher) dispatch(jobs chan<- int) {
+	defer close(jobs)
+
+	for i := range p.mappings {
+		if !p.prefetchable(i) {
+			continue
+		}
+		size := p.mappings[i].FileInfo.Size()
+
+		p.mutex.Lock()
+		for !p.closed && (p.slots == 0 || p.memory < size) {
+			p.cond.Wait()
+		}
+		if p.closed {
+			p.mutex.Unlock()
+			return
+		}
+		p.slots--
+		p.memory -= size
+		// The channel must exist before the writer may look for it
+		p.results[i] = make(chan prefetchResult, 1)
+		p.cond.Broadcast()
+		p.mutex.Unlock()
+
+		jobs <- i
+	}
+}
+
+// work retrieves the files handed out by dispatch.
+func (p *prefetcher) work(jobs <-chan int) {
+	for i := range jobs {
+		file := p.mappings[i]
+		contents := bytes.NewBuffer(make([]byte, 0, file.FileInfo.Size()))
+		err := p.storage.Retrieve(path.Join(p.relpath, file.Path), contents)
+		p.results[i] <- prefetchResult{contents: contents, err: err}
+	}
+}
+
+// Fetch returns the contents of the file with the given index if it was
+// prefetched, waiting for it if necessary. For files that are not
+// prefetched, nil is returned and they need to be retrieved by the caller.
+// Files must be fetched in order, and Release must be called once the
+// contents were written.
+func (p *prefetcher) Fetch(i int) (*bytes.Buffer, error) {
+	if !p.enabled || !p.prefetchable(i) {
+		return nil, nil
+	}
+
+	p.mutex.Lock()
+	for p.results[i] == nil {
+		p.cond.Wait()
+	}
+	results := p.results[i]
+	p.mutex.Unlock()
+
+	result := <-results
+	return result.contents, result.err
+}
+
+// Release frees the memory taken by the file with the given index, so more
+// files can be prefetched.
+func (p *prefetcher) Release(i int) {
+	if !p.enabled || !p.prefetchable(i) {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.slots++
+	p.memory += p.mappings[i].FileInfo.Size()
+	p.results[i] = nil
+	p.cond.Broadcast()
+}
+
+// Close stops prefetching. Files being retrieved at the moment are still
+// retrieved but thrown away.
+func (p *prefetcher) Close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.closed = true
+	p.cond.Broadcast()
+}
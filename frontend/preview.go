@@ -0,0 +1,193 @@
+package frontend
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kthxat/filament/backends"
+	"github.com/kthxat/filament/config"
+	"github.com/kthxat/filament/internal/highlight"
+	"github.com/kthxat/filament/internal/markdown"
+)
+
+// Kinds of files that can be shown on preview pages.
+const (
+	previewText     = "text"
+	previewMarkdown = "markdown"
+	previewImage    = "image"
+	previewPDF      = "pdf"
+	previewAudio    = "audio"
+	previewVideo    = "video"
+)
+
+// textFileNames lists names of files without extension that usually contain
+// text.
+var textFileNames = map[string]bool{
+	"authors":       true,
+	"changelog":     true,
+	"copying":       true,
+	"dockerfile":    true,
+	"license":       true,
+	"makefile":      true,
+	"readme":        true,
+	".gitignore":    true,
+	".htaccess":     true,
+	".message":      true,
+	".editorconfig": true,
+}
+
+// previewableImageTypes lists the image types browsers can show.
+var previewableImageTypes = map[string]bool{
+	"image/avif":    true,
+	"image/bmp":     true,
+	"image/gif":     true,
+	"image/jpeg":    true,
+	"image/png":     true,
+	"image/svg+xml": true,
+	"image/webp":    true,
+	"image/x-icon":  true,
+}
+
+// previewKind returns how a file can be previewed, as guessed from its name.
+// If it can't be previewed, an empty string is returned.
+func previewKind(fi os.FileInfo) string {
+	if fi.IsDir() {
+		return ""
+	}
+
+	name := strings.ToLower(fi.Name())
+	switch path.Ext(name) {
+	case ".md", ".markdown":
+		return previewMarkdown
+	}
+	if highlight.LanguageOf(name) != nil || textFileNames[name] {
+		return previewText
+	}
+
+	mimeType := fileType(fi)
+	switch {
+	case mimeType == "application/pdf":
+		return previewPDF
+	case previewableImageTypes[mimeType]:
+		return previewImage
+	case strings.HasPrefix(mimeType, "audio/"):
+		return previewAudio
+	case strings.HasPrefix(mimeType, "video/"):
+		return previewVideo
+	case strings.HasPrefix(mimeType, "text/"):
+		return previewText
+	}
+	return ""
+}
+
+// readText retrieves up to maxSize bytes of a text file. If the file is not
+// valid UTF-8 text, ok is false.
+func (r *request) readText(relpath string, maxSize int64) (text []byte, truncated, ok bool, err error) {
+	buf := new(bytes.Buffer)
+	err = backends.RetrieveRange(r.storage, relpath, 0, maxSize+1, buf)
+	if errors.Is(err, io.EOF) {
+		// Backends may complain about files ending before the range does
+		err = nil
+	} else if err != nil {
+		return
+	}
+
+	text = buf.Bytes()
+	if int64(len(text)) > maxSize {
+		// Cut at the last complete line
+		text = text[:maxSize]
+		if i := bytes.LastIndexByte(text, '\n'); i >= 0 {
+			text = text[:i+1]
+		}
+		truncated = true
+	}
+	ok = utf8.Valid(text) && bytes.IndexByte(text, 0) < 0
+	return
+}
+
+// servePreview shows a file on a page of its own, rendered according to its
+// type, with links to download it.
+func (r *request) servePreview(relpath string) {
+	fileInfo, err := r.storage.Stat(relpath)
+	if err != nil {
+		r.AbortWithError(storageErrorStatus(err), err)
+		return
+	}
+	if fileInfo.IsDir() {
+		r.AbortWithStatus(http.StatusConflict)
+		return
+	}
+	if err := backends.Authorize(r.storage, relpath, backends.OperationRead); err != nil {
+		r.AbortWithError(storageErrorStatus(err), err)
+		return
+	}
+
+	// The preview page is located at <file>/.filament/preview
+	raw := "../../" + url.PathEscape(fileInfo.Name())
+	data := gin.H{
+		"Name":     fileInfo.Name(),
+		"Path":     relpath,
+		"Kind":     previewKind(fileInfo),
+		"Raw":      raw,
+		"Download": raw + "?" + queryDownload + "=1",
+		"Back":     "../../",
+		"Size":     fileInfo.Size(),
+		"Type":     fileType(fileInfo),
+		"T": r.localizeAll(map[string]string{
+			"Download":          "Download",
+			"BackToDirectory":   "Back to files",
+			"PreviewTruncated":  "The file is too large to be shown completely.",
+			"PreviewNoPreview":  "There is no preview for this file.",
+			"PreviewNoPlayback": "Your browser can't play this file.",
+		}),
+		"ModTime": fileInfo.ModTime(),
+	}
+
+	switch kind := data["Kind"]; kind {
+	case previewText, previewMarkdown:
+		// The page shows the contents, which counts as downloading the file
+		if r.Request.Method != http.MethodHead {
+			if err := r.countShareDownload(); err != nil {
+				r.AbortWithError(shareErrorStatus(err), err)
+				return
+			}
+		}
+
+		maxSize, err := config.ParseSize(config.GetConfig().Preview.MaxTextSize)
+		if err != nil {
+			log.Printf("Invalid maximum text preview size: %s", err)
+			maxSize = 1 << 20
+		}
+
+		text, truncated, ok, err := r.readText(relpath, maxSize)
+		if err != nil {
+			r.AbortWithError(storageErrorStatus(err), err)
+			return
+		}
+		if !ok {
+			data["Kind"] = ""
+			break
+		}
+		data["Truncated"] = truncated
+
+		if kind == previewMarkdown {
+			data["Content"] = template.HTML(markdown.Render(text, &markdown.Options{
+				LinkPrefix: "../../",
+			}))
+		} else {
+			data["Content"] = template.HTML(highlight.Highlight(string(text), highlight.LanguageOf(fileInfo.Name())))
+		}
+	}
+
+	r.serveCachableHTML("preview.html", data)
+}
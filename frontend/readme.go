@@ -0,0 +1,74 @@
+package frontend
+
+import (
+	"html/template"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kthxat/filament/backends"
+	"github.com/kthxat/filament/config"
+	"github.com/kthxat/filament/internal/markdown"
+)
+
+// findReadme returns the README file of a listing that is preferred most, or
+// nil if there is none.
+func findReadme(files []os.FileInfo, names []string) os.FileInfo {
+	for _, name := range names {
+		for _, fi := range files {
+			if !fi.IsDir() && strings.EqualFold(fi.Name(), name) {
+				return fi
+			}
+		}
+	}
+	return nil
+}
+
+// readme renders the README file of a directory for its listing. If there is
+// none or it can't be shown, nil is returned.
+func (r *request) readme(relpath string, files []os.FileInfo) gin.H {
+	readmeConfig := config.GetConfig().Readme
+	fi := findReadme(files, readmeConfig.Names)
+	if fi == nil {
+		return nil
+	}
+
+	p := path.Join(relpath, fi.Name())
+	if backends.Authorize(r.storage, p, backends.OperationRead) != nil {
+		return nil
+	}
+
+	maxSize, err := config.ParseSize(readmeConfig.MaxSize)
+	if err != nil {
+		log.Printf("Invalid maximum README size: %s", err)
+		maxSize = 64 << 10
+	}
+	text, truncated, ok, err := r.readText(p, maxSize)
+	if err != nil {
+		log.Printf("Reading %s threw an error: %s", p, err)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+
+	readme := gin.H{
+		"Name":      fi.Name(),
+		"Link":      "./" + url.PathEscape(fi.Name()) + "/" + relPathPreview,
+		"Above":     readmeConfig.Position == config.ReadmeAbove,
+		"Truncated": truncated,
+		"T": r.localizeAll(map[string]string{
+			"PreviewTruncated": "The file is too large to be shown completely.",
+		}),
+	}
+	switch strings.ToLower(path.Ext(fi.Name())) {
+	case ".md", ".markdown":
+		readme["Markdown"] = template.HTML(markdown.Render(text, nil))
+	default:
+		readme["Text"] = string(text)
+	}
+	return readme
+}
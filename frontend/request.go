@@ -0,0 +1,186 @@
+package frontend
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kthxat/filament/app"
+	"github.com/kthxat/filament/backends"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// request bundles everything needed to serve paths of a storage.
+type request struct {
+	*gin.Context
+
+	server *FrontendServer
+
+	session   *app.Session
+	storage   backends.Storage
+	localizer *i18n.Localizer
+
+	// share is set if the request was made through a share link.
+	share *app.Share
+}
+
+func (f *FrontendServer) newRequest(c *gin.Context, session *app.Session, storage backends.Storage) *request {
+	return &request{
+		Context:   c,
+		server:    f,
+		session:   session,
+		storage:   storage,
+		localizer: f.newLocalizer(c, session.Language()),
+	}
+}
+
+// newLocalizer returns a localizer for the given preferred language, falling
+// back to the languages the browser asks for.
+func (f *FrontendServer) newLocalizer(c *gin.Context, lang string) *i18n.Localizer {
+	return i18n.NewLocalizer(f.i18n, lang, c.GetHeader("Accept-Language"))
+}
+
+// localize returns the translation of a message, falling back to the given
+// English text.
+func (r *request) localize(id, other string) string {
+	localized, err := r.localizer.Localize(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    id,
+			Other: other,
+		},
+	})
+	if err != nil {
+		r.Error(err)
+		return other
+	}
+	return localized
+}
+
+// localizeAll localizes a set of messages, given as a map of message IDs to
+// English texts, for use in templates.
+func (r *request) localizeAll(messages map[string]string) map[string]string {
+	localized := make(map[string]string, len(messages))
+	for id, other := range messages {
+		localized[id] = r.localize(id, other)
+	}
+	return localized
+}
+
+// absoluteURL turns an absolute path on this server into a URL that can be
+// handed out to others.
+func (r *request) absoluteURL(p string) string {
+	scheme := "http"
+	if r.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.GetHeader("X-Forwarded-Proto"); len(proto) > 0 {
+		scheme = proto
+	}
+	return scheme + "://" + r.Request.Host + p
+}
+
+// serveCachableHTML renders a template into memory first, so the client can
+// be told when it already has the exact same page.
+func (r *request) serveCachableHTML(name string, data interface{}) {
+	w := &bufferResponseWriter{header: http.Header{}}
+	if err := r.server.html.Instance(name, data).Render(w); err != nil {
+		r.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	etag := contentETag(w.Bytes())
+	r.setValidators(etag, time.Time{})
+	if r.isNotModified(etag, time.Time{}) {
+		r.Status(http.StatusNotModified)
+		return
+	}
+
+	// Set explicitly, as net/http can only tell the length of HEAD responses
+	// with short bodies
+	r.Header("content-length", strconv.Itoa(len(w.Bytes())))
+	r.Data(http.StatusOK, "text/html; charset=utf-8", w.Bytes())
+}
+
+// serve handles actions on paths and otherwise serves files and directories.
+func (r *request) serve(relpath string) {
+	if strings.HasSuffix(relpath, "/"+relPathShare) {
+		r.serveShareForm(strings.TrimSuffix(relpath, "/"+relPathShare))
+		return
+	}
+
+	if strings.HasSuffix(relpath, "/"+relPathArchive) {
+		r.serveArchiveSelection(strings.TrimSuffix(relpath, relPathArchive))
+		return
+	}
+
+	if strings.HasSuffix(relpath, "/"+relPathHidden) {
+		r.serveShowHidden()
+		return
+	}
+
+	if r.Request.Method != http.MethodGet && r.Request.Method != http.MethodHead {
+		r.AbortWithStatus(http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch {
+	case strings.HasSuffix(relpath, "/"+relPathArchiveZip):
+		r.serveArchive(strings.TrimSuffix(relpath, relPathArchiveZip), archiveFormatByExtension("zip"))
+		return
+
+	case strings.HasSuffix(relpath, "/"+relPathArchiveTar):
+		r.serveArchive(strings.TrimSuffix(relpath, relPathArchiveTar), archiveFormatByExtension("tar"))
+		return
+
+	case strings.HasSuffix(relpath, "/"+relPathArchiveTarGZip):
+		r.serveArchive(strings.TrimSuffix(relpath, relPathArchiveTarGZip), archiveFormatByExtension("tar.gz"))
+		return
+
+	case strings.HasSuffix(relpath, "/"+relPathSearch):
+		r.serveSearch(strings.TrimSuffix(relpath, relPathSearch))
+		return
+
+	case strings.HasSuffix(relpath, "/"+relPathPreview):
+		r.servePreview(strings.TrimSuffix(relpath, "/"+relPathPreview))
+		return
+
+	case strings.HasSuffix(relpath, "/"+relPathThumbnail):
+		r.serveThumbnail(strings.TrimSuffix(relpath, "/"+relPathThumbnail))
+		return
+
+	case strings.HasSuffix(relpath, "/"+relPathChecksum):
+		r.serveChecksum(strings.TrimSuffix(relpath, "/"+relPathChecksum))
+		return
+
+	case strings.HasSuffix(relpath, "/"+relPathChecksums):
+		r.serveChecksums(strings.TrimSuffix(relpath, relPathChecksums))
+		return
+
+	case
+		strings.HasSuffix(relpath, "/"+relPathArchiveTarBZip2),
+		strings.HasSuffix(relpath, "/"+relPathArchiveTarXZ),
+		strings.HasSuffix(relpath, "/"+relPathArchiveTar7Zip):
+		r.AbortWithStatus(http.StatusNotImplemented)
+		return
+	case strings.HasSuffix(relpath, "/.filament/archive.7z"):
+		r.AbortWithStatus(http.StatusNotImplemented)
+		return
+	}
+
+	fileInfo, err := r.storage.Stat(relpath)
+	if err != nil {
+		r.AbortWithError(storageErrorStatus(err), err)
+		return
+	}
+
+	if fileInfo.IsDir() {
+		// HEAD requests are answered like GET requests, which tells the same
+		// entity tag and length, and the body is left out by net/http
+		r.serveDirectory(relpath)
+		return
+	}
+
+	r.serveFile(relpath, fileInfo)
+}
@@ -0,0 +1,342 @@
+package frontend
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kthxat/filament/backends"
+	"github.com/kthxat/filament/config"
+)
+
+// Ways search queries are matched against names.
+const (
+	searchModeSubstring = "substring"
+	searchModeGlob      = "glob"
+	searchModeRegex     = "regex"
+)
+
+// searchDateFormat is the format of dates users filter searches by.
+const searchDateFormat = "2006-01-02"
+
+var errSearchStopped = errors.New("search stopped")
+
+// searchQuery describes what a user is searching for.
+type searchQuery struct {
+	// Text is matched against names in the given mode
+	Text string
+	Mode string
+	re   *regexp.Regexp
+
+	// Sizes are only checked if they are 0 or more
+	MinSize, MaxSize int64
+	// Dates are only checked if they are not zero
+	After, Before time.Time
+}
+
+// parseSearchQuery reads a search query from the q, mode, minsize, maxsize,
+// after and before query parameters. If none of them is given, nil is
+// returned.
+func (r *request) parseSearchQuery() (*searchQuery, error) {
+	q := &searchQuery{
+		Text:    r.Query("q"),
+		Mode:    r.DefaultQuery("mode", searchModeSubstring),
+		MinSize: -1,
+		MaxSize: -1,
+	}
+	isEmpty := len(q.Text) == 0
+
+	switch q.Mode {
+	case searchModeSubstring, searchModeGlob:
+		q.Text = strings.ToLower(q.Text)
+		if q.Mode == searchModeGlob {
+			if _, err := path.Match(q.Text, ""); err != nil {
+				return nil, err
+			}
+		}
+	case searchModeRegex:
+		if _, err := regexp.Compile(q.Text); err != nil {
+			return nil, err
+		}
+		q.re = regexp.MustCompile("(?i)" + q.Text)
+	default:
+		return nil, errors.New("unknown search mode " + strconv.Quote(q.Mode))
+	}
+
+	for _, size := range []struct {
+		param string
+		value *int64
+	}{
+		{"minsize", &q.MinSize},
+		{"maxsize", &q.MaxSize},
+	} {
+		if s := r.Query(size.param); len(s) > 0 {
+			var err error
+			if *size.value, err = config.ParseSize(s); err != nil {
+				return nil, err
+			}
+			isEmpty = false
+		}
+	}
+
+	if after := r.Query("after"); len(after) > 0 {
+		var err error
+		if q.After, err = time.ParseInLocation(searchDateFormat, after, time.Local); err != nil {
+			return nil, err
+		}
+		isEmpty = false
+	}
+	if before := r.Query("before"); len(before) > 0 {
+		day, err := time.ParseInLocation(searchDateFormat, before, time.Local)
+		if err != nil {
+			return nil, err
+		}
+		// Includes the whole day
+		q.Before = day.AddDate(0, 0, 1)
+		isEmpty = false
+	}
+
+	if isEmpty {
+		return nil, nil
+	}
+	return q, nil
+}
+
+// Matches returns whether a file with the given properties matches the
+// query.
+func (q *searchQuery) Matches(name string, isDir bool, size int64, modTime time.Time) bool {
+	switch q.Mode {
+	case searchModeSubstring:
+		if !strings.Contains(strings.ToLower(name), q.Text) {
+			return false
+		}
+	case searchModeGlob:
+		if len(q.Text) > 0 {
+			if ok, _ := path.Match(q.Text, strings.ToLower(name)); !ok {
+				return false
+			}
+		}
+	case searchModeRegex:
+		if !q.re.MatchString(name) {
+			return false
+		}
+	}
+
+	if q.MinSize >= 0 || q.MaxSize >= 0 {
+		// Directories have no meaningful size
+		if isDir ||
+			(q.MinSize >= 0 && size < q.MinSize) ||
+			(q.MaxSize >= 0 && size > q.MaxSize) {
+			return false
+		}
+	}
+
+	if (!q.After.IsZero() && modTime.Before(q.After)) ||
+		(!q.Before.IsZero() && !modTime.Before(q.Before)) {
+		return false
+	}
+
+	return true
+}
+
+// searchState is shared between a running search and the template showing
+// its results. Count and Stopped may only be read once Results is closed.
+type searchState struct {
+	Results chan gin.H
+	Count   int
+	// Stopped explains why the search stopped before it was complete
+	Stopped string
+}
+
+// escapePath escapes every element of a slash separated path.
+func escapePath(p string) string {
+	elements := strings.Split(p, "/")
+	for i, element := range elements {
+		elements[i] = url.PathEscape(element)
+	}
+	return strings.Join(elements, "/")
+}
+
+// searchResult describes a found file for the search template. Links are
+// relative to the search page, which is located in the directory being
+// searched.
+func searchResult(p string, fi os.FileInfo) gin.H {
+	link := "../" + escapePath(p)
+	if fi.IsDir() {
+		link += "/"
+	}
+	result := gin.H{
+		"Name":    fi.Name(),
+		"Path":    p,
+		"Link":    link,
+		"IsDir":   fi.IsDir(),
+		"Size":    fi.Size(),
+		"ModTime": fi.ModTime(),
+	}
+	return result
+}
+
+// serveSearch searches the directory relpath and everything below it,
+// sending results as soon as they are found.
+func (r *request) serveSearch(relpath string) {
+	fileInfo, err := r.storage.Stat(relpath)
+	if err != nil {
+		r.AbortWithError(storageErrorStatus(err), err)
+		return
+	}
+	if !fileInfo.IsDir() {
+		r.AbortWithStatus(http.StatusConflict)
+		return
+	}
+	if err := backends.Authorize(r.storage, relpath, backends.OperationList); err != nil {
+		r.AbortWithError(storageErrorStatus(err), err)
+		return
+	}
+
+	searchConfig := config.GetConfig().Search
+	depth := searchConfig.MaxDepth
+	if s := r.Query("depth"); len(s) > 0 {
+		if d, err := strconv.Atoi(s); err == nil && d > 0 && (depth <= 0 || d < depth) {
+			depth = d
+		}
+	}
+
+	data := gin.H{
+		"Path": relpath,
+		"Form": gin.H{
+			"Q":       r.Query("q"),
+			"Mode":    r.DefaultQuery("mode", searchModeSubstring),
+			"MinSize": r.Query("minsize"),
+			"MaxSize": r.Query("maxsize"),
+			"After":   r.Query("after"),
+			"Before":  r.Query("before"),
+			"Depth":   r.Query("depth"),
+		},
+		"T": r.localizeAll(map[string]string{
+			"Search":            "Search",
+			"SearchIn":          "Search in",
+			"SearchMinSize":     "At least",
+			"SearchMaxSize":     "At most",
+			"SearchAfter":       "Modified after",
+			"SearchBefore":      "Modified before",
+			"SearchDepth":       "Levels",
+			"SearchNoResults":   "Nothing found.",
+			"SearchResults":     "Results",
+			"ColumnName":        "Name",
+			"ColumnSize":        "Size",
+			"ColumnModified":    "Modified",
+			"BackToDirectory":   "Back to files",
+			"SearchStoppedTime": "The search took too long and was stopped early.",
+			"SearchStoppedMore": "There are more results than can be shown.",
+		}),
+	}
+
+	modes := []gin.H{}
+	for _, mode := range []struct{ Value, ID, Other string }{
+		{searchModeSubstring, "SearchModeSubstring", "Name contains"},
+		{searchModeGlob, "SearchModeGlob", "Wildcard pattern"},
+		{searchModeRegex, "SearchModeRegex", "Regular expression"},
+	} {
+		modes = append(modes, gin.H{
+			"Value":    mode.Value,
+			"Name":     r.localize(mode.ID, mode.Other),
+			"Selected": mode.Value == r.Query("mode"),
+		})
+	}
+	data["Modes"] = modes
+
+	if backends.Authorize(r.storage, relpath, backends.OperationArchive) == nil {
+		// The form is sent from the search page, next to the archive link
+		data["Archive"] = r.archiveForm(path.Base(relPathArchive))
+	}
+
+	query, err := r.parseSearchQuery()
+	if err != nil {
+		data["Error"] = err.Error()
+	}
+	if query == nil || r.Request.Method == http.MethodHead {
+		r.HTML(http.StatusOK, "search.html", data)
+		return
+	}
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if searchConfig.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(r.Request.Context(), searchConfig.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(r.Request.Context())
+	}
+	defer cancel()
+
+	state := &searchState{Results: make(chan gin.H)}
+	data["Search"] = state
+
+	go func() {
+		defer close(state.Results)
+
+		base := strings.TrimSuffix(path.Clean("/"+relpath), "/") + "/"
+		err := backends.Search(r.storage, relpath, depth, func(pwd string, fi os.FileInfo, err error) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err != nil {
+				// Leave out what can't be read
+				return nil
+			}
+			if !query.Matches(fi.Name(), fi.IsDir(), fi.Size(), fi.ModTime()) {
+				return nil
+			}
+			// An index is crawled with another account and may be out of
+			// date, so results are only shown as the user sees them now
+			fi, err = r.storage.Stat(path.Join(pwd, fi.Name()))
+			if err != nil || !query.Matches(fi.Name(), fi.IsDir(), fi.Size(), fi.ModTime()) {
+				return nil
+			}
+			if searchConfig.MaxResults > 0 && state.Count >= searchConfig.MaxResults {
+				return errSearchStopped
+			}
+
+			p := strings.TrimPrefix(path.Join(pwd, fi.Name()), base)
+			select {
+			case state.Results <- searchResult(p, fi):
+				state.Count++
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		switch {
+		case errors.Is(err, errSearchStopped):
+			state.Stopped = "SearchStoppedMore"
+		case errors.Is(err, context.DeadlineExceeded):
+			state.Stopped = "SearchStoppedTime"
+		}
+	}()
+
+	r.Header("content-type", "text/html; charset=utf-8")
+	r.Status(http.StatusOK)
+	w := &flushingResponseWriter{r.Writer}
+	if err := r.server.html.Instance("search.html", data).Render(w); err != nil {
+		r.Error(err)
+	}
+}
+
+// flushingResponseWriter sends everything written to it right away, so
+// clients can show pages while they are being rendered.
+type flushingResponseWriter struct {
+	gin.ResponseWriter
+}
+
+func (w *flushingResponseWriter) Write(p []byte) (n int, err error) {
+	n, err = w.ResponseWriter.Write(p)
+	w.Flush()
+	return
+}
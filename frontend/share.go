@@ -0,0 +1,311 @@
+package frontend
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kthxat/filament/app"
+	"github.com/kthxat/filament/backends"
+	"github.com/kthxat/filament/config"
+)
+
+// shareLifetimes are the lifetimes users can choose from when creating a
+// share, limited by the configured maximum.
+var shareLifetimes = []struct {
+	ID, Other string
+	Lifetime  time.Duration
+}{
+	{"ShareLifetimeHour", "1 hour", time.Hour},
+	{"ShareLifetimeDay", "1 day", 24 * time.Hour},
+	{"ShareLifetimeWeek", "1 week", 7 * 24 * time.Hour},
+	{"ShareLifetimeMonth", "30 days", 30 * 24 * time.Hour},
+}
+
+// shareVisitDuration is how long downloads through a share are counted only
+// once, see countShareDownload.
+const shareVisitDuration = time.Hour
+
+// shareErrorStatus returns the HTTP status code matching an error returned
+// when dealing with shares.
+func shareErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, app.ErrShareNotFound), errors.Is(err, app.ErrSharesDisabled):
+		return http.StatusNotFound
+	case errors.Is(err, app.ErrShareExhausted):
+		return http.StatusGone
+	}
+	return http.StatusInternalServerError
+}
+
+// canShare returns whether the user may create shares in this request.
+func (r *request) canShare() bool {
+	return r.share == nil &&
+		!r.session.IsAnonymous() &&
+		app.SharesEnabled()
+}
+
+// shareLink returns the URL visitors can open a share with.
+func (r *request) shareLink(share *app.Share) string {
+	link := "/" + relPathShareLinks + "/" + app.ShareToken(share) + "/"
+	if !share.IsDir {
+		link += url.PathEscape(path.Base(share.Path))
+	}
+	return r.absoluteURL(link)
+}
+
+// serveShareForm lets users create a share for the given path.
+func (r *request) serveShareForm(relpath string) {
+	if !r.canShare() {
+		r.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	fileInfo, err := r.storage.Stat(relpath)
+	if err != nil {
+		r.AbortWithError(storageErrorStatus(err), err)
+		return
+	}
+
+	op := backends.OperationRead
+	if fileInfo.IsDir() {
+		op = backends.OperationList
+	}
+	if err := backends.Authorize(r.storage, relpath, op); err != nil {
+		r.AbortWithError(storageErrorStatus(err), err)
+		return
+	}
+
+	sharesConfig := config.GetConfig().Shares
+
+	if r.Request.Method == http.MethodPost {
+		if !isSameOrigin(r.Request) {
+			r.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+
+		// Visitors will access the storage with the credentials of the owner
+		username, password, ok := parseBasicAuth(r.GetHeader("Authorization"))
+		if !ok || username != r.session.Username() {
+			r.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+
+		options := &app.ShareOptions{
+			Password: r.PostForm("password"),
+		}
+		if lifetime := r.PostForm("lifetime"); len(lifetime) > 0 {
+			options.Lifetime, err = time.ParseDuration(lifetime)
+			if err != nil {
+				r.AbortWithError(http.StatusBadRequest, err)
+				return
+			}
+		}
+		if maxDownloads := r.PostForm("max_downloads"); len(maxDownloads) > 0 {
+			options.MaxDownloads, err = strconv.Atoi(maxDownloads)
+			if err != nil || options.MaxDownloads < 0 {
+				r.AbortWithStatus(http.StatusBadRequest)
+				return
+			}
+		}
+
+		share, err := app.CreateShare(username, password, relpath, fileInfo.IsDir(), options)
+		if err != nil {
+			r.AbortWithError(shareErrorStatus(err), err)
+			return
+		}
+
+		r.Redirect(http.StatusSeeOther, "/"+relPathShares+"?created="+url.QueryEscape(share.ID))
+		return
+	}
+
+	lifetimes := []gin.H{}
+	for _, option := range shareLifetimes {
+		if sharesConfig.MaxLifetime > 0 && option.Lifetime > sharesConfig.MaxLifetime {
+			continue
+		}
+		lifetimes = append(lifetimes, gin.H{
+			"Name":     r.localize(option.ID, option.Other),
+			"Value":    option.Lifetime.String(),
+			"Selected": option.Lifetime == sharesConfig.DefaultLifetime,
+		})
+	}
+
+	r.HTML(http.StatusOK, "share_form.html", gin.H{
+		"Path":      relpath,
+		"IsDir":     fileInfo.IsDir(),
+		"Lifetimes": lifetimes,
+		"T": r.localizeAll(map[string]string{
+			"CreateShare":       "Create share link",
+			"ShareLifetime":     "Expires after",
+			"SharePassword":     "Password (optional)",
+			"ShareMaxDownloads": "Maximum number of downloads (0 for unlimited)",
+		}),
+	})
+}
+
+// serveShares lists the shares of the user and allows revoking them.
+func (r *request) serveShares() {
+	if !r.canShare() {
+		r.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	username := r.session.Username()
+
+	if r.Request.Method == http.MethodPost {
+		if !isSameOrigin(r.Request) {
+			r.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		if err := app.RevokeShare(username, r.PostForm("revoke")); err != nil {
+			r.AbortWithError(shareErrorStatus(err), err)
+			return
+		}
+		r.Redirect(http.StatusSeeOther, "/"+relPathShares)
+		return
+	}
+
+	created := r.Query("created")
+	shares := []gin.H{}
+	for _, share := range app.SharesOf(username) {
+		shares = append(shares, gin.H{
+			"ID":           share.ID,
+			"Path":         share.Path,
+			"IsDir":        share.IsDir,
+			"Link":         r.shareLink(share),
+			"Expires":      share.Expires,
+			"Downloads":    share.Downloads,
+			"MaxDownloads": share.MaxDownloads,
+			"HasPassword":  share.HasPassword(),
+			"IsNew":        share.ID == created,
+		})
+	}
+
+	r.HTML(http.StatusOK, "shares.html", gin.H{
+		"Shares": shares,
+		"T": r.localizeAll(map[string]string{
+			"Shares":          "Shares",
+			"NoShares":        "You have not shared anything yet.",
+			"SharePath":       "Path",
+			"ShareLink":       "Link",
+			"ShareExpires":    "Expires",
+			"ShareDownloads":  "Downloads",
+			"ShareProtected":  "Password protected",
+			"RevokeShare":     "Revoke",
+			"ShareCreated":    "Share created, send this link:",
+			"BackToDirectory": "Back to files",
+		}),
+	})
+}
+
+// handleShareLink serves a request made through a share link. rest is the
+// part of the path following the share link prefix.
+func (f *FrontendServer) handleShareLink(c *gin.Context, rest string) {
+	token, innerPath, hasSlash := strings.Cut(rest, "/")
+	if !hasSlash {
+		c.Redirect(http.StatusTemporaryRedirect, c.Request.URL.EscapedPath()+"/")
+		return
+	}
+
+	share, err := app.ResolveShare(token)
+	if err != nil {
+		c.AbortWithError(shareErrorStatus(err), err)
+		return
+	}
+
+	if share.HasPassword() && !f.unlockShare(c, token, share) {
+		return
+	}
+
+	session, storage, err := app.OpenShare(share)
+	if err != nil {
+		c.AbortWithError(shareErrorStatus(err), err)
+		return
+	}
+
+	session.Increment()
+	defer session.Decrement()
+
+	r := f.newRequest(c, session, storage)
+	r.share = share
+	r.serve("/" + innerPath)
+}
+
+// countShareDownload records a download through the share of the request,
+// once per visit. The first download starts a visit by setting a signed
+// cookie, so continuing a download, seeking in a video or loading the files
+// shown on a preview page don't use up the share. Clients without the cookie
+// are counted every time.
+func (r *request) countShareDownload() error {
+	if r.share == nil {
+		return nil
+	}
+
+	cookieName := "filament_visit_" + r.share.ID
+	if value, err := r.Cookie(cookieName); err == nil && r.share.VerifyVisitToken(value) {
+		return nil
+	}
+
+	if err := app.CountShareDownload(r.share.ID); err != nil {
+		return err
+	}
+	expires := time.Now().Add(shareVisitDuration)
+	http.SetCookie(r.Writer, &http.Cookie{
+		Name:     cookieName,
+		Value:    r.share.VisitToken(expires),
+		Path:     "/" + relPathShareLinks + "/" + app.ShareToken(r.share) + "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.Request.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// unlockShare makes sure the visitor has entered the password of a share,
+// asking for it if necessary. It returns whether the request may proceed.
+func (f *FrontendServer) unlockShare(c *gin.Context, token string, share *app.Share) bool {
+	cookieName := "filament_share_" + share.ID
+	if value, err := c.Cookie(cookieName); err == nil && value == share.UnlockToken() {
+		return true
+	}
+
+	wrongPassword := false
+	if c.Request.Method == http.MethodPost {
+		if share.VerifyPassword(c.PostForm("password")) {
+			http.SetCookie(c.Writer, &http.Cookie{
+				Name:     cookieName,
+				Value:    share.UnlockToken(),
+				Path:     "/" + relPathShareLinks + "/" + token + "/",
+				Expires:  share.Expires,
+				HttpOnly: true,
+				Secure:   c.Request.TLS != nil,
+				SameSite: http.SameSiteLaxMode,
+			})
+			c.Redirect(http.StatusSeeOther, c.Request.URL.EscapedPath())
+			return false
+		}
+		wrongPassword = true
+	}
+
+	r := &request{
+		Context:   c,
+		localizer: f.newLocalizer(c, ""),
+	}
+	c.HTML(http.StatusForbidden, "share_password.html", gin.H{
+		"WrongPassword": wrongPassword,
+		"T": r.localizeAll(map[string]string{
+			"ShareNeedsPassword": "This share is protected by a password.",
+			"SharePassword":      "Password",
+			"WrongPassword":      "The password is wrong.",
+			"Unlock":             "Open",
+		}),
+	})
+	return false
+}
@@ -0,0 +1,158 @@
+package frontend
+
+import (
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Keys listings can be sorted by.
+const (
+	sortByName  = "name"
+	sortBySize  = "size"
+	sortByMTime = "mtime"
+	sortByType  = "type"
+)
+
+// listingOrder describes how a directory listing is sorted.
+type listingOrder struct {
+	Key        string
+	Descending bool
+	DirsFirst  bool
+}
+
+// parseListingOrder reads the order of a listing from the sort, order and
+// dirsfirst query parameters. By default, listings are sorted by name with
+// directories first.
+func (r *request) parseListingOrder() listingOrder {
+	order := listingOrder{
+		Key:        r.DefaultQuery(querySort, sortByName),
+		Descending: r.Query(queryOrder) == "desc",
+		DirsFirst:  r.DefaultQuery(queryDirsFirst, "1") != "0",
+	}
+	switch order.Key {
+	case sortByName, sortBySize, sortByMTime, sortByType:
+	default:
+		order.Key = sortByName
+	}
+	return order
+}
+
+// Query returns the query string selecting this order.
+func (o listingOrder) Query() string {
+	values := url.Values{}
+	values.Set(querySort, o.Key)
+	if o.Descending {
+		values.Set(queryOrder, "desc")
+	} else {
+		values.Set(queryOrder, "asc")
+	}
+	if !o.DirsFirst {
+		values.Set(queryDirsFirst, "0")
+	}
+	return "?" + values.Encode()
+}
+
+// fileType returns the MIME type of a file as guessed from its name, without
+// parameters. For directories and unknown types, an empty string is
+// returned.
+func fileType(fi os.FileInfo) string {
+	if fi.IsDir() {
+		return ""
+	}
+	mimeType, _, _ := strings.Cut(mime.TypeByExtension(path.Ext(fi.Name())), ";")
+	return mimeType
+}
+
+// sortFiles sorts a listing in the given order. Files that are equal
+// regarding the key are sorted by name.
+func sortFiles(files []os.FileInfo, order listingOrder) {
+	sort.SliceStable(files, func(i, j int) bool {
+		a := files[i]
+		b := files[j]
+		if order.DirsFirst && a.IsDir() != b.IsDir() {
+			return a.IsDir()
+		}
+
+		c := 0
+		switch order.Key {
+		case sortBySize:
+			c = compareInt64(a.Size(), b.Size())
+		case sortByMTime:
+			c = a.ModTime().Compare(b.ModTime())
+		case sortByType:
+			c = naturalCompare(fileType(a), fileType(b))
+		}
+		if c == 0 {
+			c = naturalCompare(a.Name(), b.Name())
+		}
+		if order.Descending {
+			return c > 0
+		}
+		return c < 0
+	})
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// naturalCompare compares two strings the way humans would, ignoring case
+// and comparing numbers in them by their value, so "file9" comes before
+// "file10". Strings that only differ in case or leading zeros are compared
+// byte by byte to keep the order total.
+func naturalCompare(a, b string) int {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if isDigit(a[i]) && isDigit(b[j]) {
+			// Compare whole numbers, ignoring leading zeros
+			startA, startB := i, j
+			for i < len(a) && isDigit(a[i]) {
+				i++
+			}
+			for j < len(b) && isDigit(b[j]) {
+				j++
+			}
+			numberA := strings.TrimLeft(a[startA:i], "0")
+			numberB := strings.TrimLeft(b[startB:j], "0")
+			if len(numberA) != len(numberB) {
+				return compareInt64(int64(len(numberA)), int64(len(numberB)))
+			}
+			if c := strings.Compare(numberA, numberB); c != 0 {
+				return c
+			}
+			continue
+		}
+
+		runeA, sizeA := utf8.DecodeRuneInString(a[i:])
+		runeB, sizeB := utf8.DecodeRuneInString(b[j:])
+		if c := compareInt64(int64(unicode.ToLower(runeA)), int64(unicode.ToLower(runeB))); c != 0 {
+			return c
+		}
+		i += sizeA
+		j += sizeB
+	}
+
+	switch {
+	case i < len(a):
+		return 1
+	case j < len(b):
+		return -1
+	}
+	return strings.Compare(a, b)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
@@ -0,0 +1,108 @@
+package frontend
+
+import (
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	rice "github.com/GeertJohan/go.rice"
+	humanize "github.com/dustin/go-humanize"
+	gintemplate "github.com/foolin/gin-template"
+	"github.com/foolin/gin-template/supports/gorice"
+	"github.com/kthxat/filament/config"
+)
+
+// mimeIcons maps MIME types and, if a type is not listed, the part before
+// the slash to the icon shown for files of that type.
+var mimeIcons = map[string]string{
+	"inode/directory":              "\U0001F4C1",
+	"application/pdf":              "\U0001F4D5",
+	"application/zip":              "\U0001F4E6",
+	"application/gzip":             "\U0001F4E6",
+	"application/x-tar":            "\U0001F4E6",
+	"application/x-bzip2":          "\U0001F4E6",
+	"application/x-xz":             "\U0001F4E6",
+	"application/x-7z-compressed":  "\U0001F4E6",
+	"application/x-rar-compressed": "\U0001F4E6",
+	"audio":                        "\U0001F3B5",
+	"image":                        "\U0001F5BC",
+	"text":                         "\U0001F4DD",
+	"video":                        "\U0001F39E",
+}
+
+// defaultIcon is shown for files of unknown type.
+const defaultIcon = "\U0001F4C4"
+
+// site holds the settings of the theme that templates use.
+type site struct {
+	Title       string
+	Logo        string
+	CSS         template.CSS
+	ColorScheme string
+}
+
+// newTemplateEngine sets up the engine rendering the embedded templates,
+// which may be overridden by files in the configured template directory.
+func newTemplateEngine() *gintemplate.TemplateEngine {
+	box := rice.MustFindBox("templates")
+	engine := gorice.NewWithConfig(box, gintemplate.TemplateConfig{
+		Funcs: template.FuncMap{
+			"humanize_bytes": func(bytes int64) string {
+				return humanize.Bytes(uint64(bytes))
+			},
+			"format_time": formatTime,
+			"mime_icon":   mimeIcon,
+			"site":        currentSite,
+		},
+	})
+
+	dir := config.GetConfig().Theme.TemplateDirectory
+	if len(dir) > 0 {
+		embedded := gorice.FileHandler(box)
+		engine.SetFileHandler(func(cfg gintemplate.TemplateConfig, name string) (string, error) {
+			content, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name+cfg.Extension)))
+			if os.IsNotExist(err) {
+				return embedded(cfg, name)
+			}
+			return string(content), err
+		})
+	}
+	return engine
+}
+
+// currentSite returns the theme settings for templates.
+func currentSite() *site {
+	theme := config.GetConfig().Theme
+	return &site{
+		Title:       theme.Title,
+		Logo:        theme.Logo,
+		CSS:         template.CSS(theme.CSS),
+		ColorScheme: theme.ColorScheme,
+	}
+}
+
+// formatTime formats a point in time for templates, using the configured
+// date format unless a layout is given. Zero times result in an empty string.
+func formatTime(t time.Time, layout ...string) string {
+	if t.IsZero() {
+		return ""
+	}
+	if len(layout) > 0 {
+		return t.Format(layout[0])
+	}
+	return t.Format(config.GetConfig().Theme.DateFormat)
+}
+
+// mimeIcon returns an icon for files of the given MIME type.
+func mimeIcon(mimeType string) string {
+	if icon, ok := mimeIcons[mimeType]; ok {
+		return icon
+	}
+	major, _, _ := strings.Cut(mimeType, "/")
+	if icon, ok := mimeIcons[major]; ok {
+		return icon
+	}
+	return defaultIcon
+}
@@ -0,0 +1,203 @@
+package frontend
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kthxat/filament/backends"
+	"github.com/kthxat/filament/backends/filecache"
+	"github.com/kthxat/filament/config"
+	"github.com/kthxat/filament/internal/thumbnail"
+)
+
+// thumbnailTypes lists the image types thumbnails can be made of.
+var thumbnailTypes = map[string]bool{
+	"image/gif":  true,
+	"image/jpeg": true,
+	"image/png":  true,
+}
+
+var (
+	thumbnailStore      *filecache.Store
+	thumbnailStoreErr   error
+	thumbnailStoreMutex sync.Mutex
+
+	// thumbnailSlots limits how many thumbnails are made at the same time,
+	// as decoding images takes a lot of CPU time and memory.
+	thumbnailSlots = make(chan struct{}, runtime.NumCPU())
+)
+
+// hasThumbnail returns whether a thumbnail can be made of the given file.
+func hasThumbnail(fi os.FileInfo) bool {
+	return !fi.IsDir() && thumbnailTypes[fileType(fi)]
+}
+
+// getThumbnailStore returns the store thumbnails are cached in. If it can't
+// be set up, nil is returned and thumbnails are made on every request.
+func getThumbnailStore(thumbnailConfig *config.ThumbnailConfig) *filecache.Store {
+	thumbnailStoreMutex.Lock()
+	defer thumbnailStoreMutex.Unlock()
+
+	if thumbnailStore == nil && thumbnailStoreErr == nil {
+		var maxSize int64
+		maxSize, thumbnailStoreErr = config.ParseSize(thumbnailConfig.CacheSize)
+		if thumbnailStoreErr == nil {
+			thumbnailStore, thumbnailStoreErr = filecache.NewStore(thumbnailConfig.Directory, maxSize)
+		}
+		if thumbnailStoreErr != nil {
+			log.Printf("Setting up thumbnail cache threw an error, thumbnails will not be cached: %s",
+				thumbnailStoreErr.Error())
+		}
+	}
+	return thumbnailStore
+}
+
+// thumbnailName returns the name a thumbnail is cached under. Besides the
+// file, it depends on who is asking, as the same path may refer to different
+// files for different users and share links.
+func (r *request) thumbnailName(relpath string, fi os.FileInfo, size int) string {
+	owner := r.session.Username()
+	if r.session.IsAnonymous() {
+		owner = "\x00anonymous"
+	}
+	if r.share != nil {
+		owner = "\x00share\x00" + r.share.ID
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%d\x00%d\x00%d", owner, relpath, fi.Size(), fi.ModTime().UnixNano(), size)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// thumbnailETag derives the entity tag of a thumbnail of the given size from
+// that of the image.
+func thumbnailETag(fi os.FileInfo, size int) string {
+	return strings.TrimSuffix(fileETag(fi), `"`) + fmt.Sprintf(`-t%d"`, size)
+}
+
+// serveThumbnail serves a small JPEG version of an image. Thumbnails are
+// cached on disk until the image changes.
+func (r *request) serveThumbnail(relpath string) {
+	fileInfo, err := r.storage.Stat(relpath)
+	if err != nil {
+		r.AbortWithError(storageErrorStatus(err), err)
+		return
+	}
+	if fileInfo.IsDir() {
+		r.AbortWithStatus(http.StatusConflict)
+		return
+	}
+	if err := backends.Authorize(r.storage, relpath, backends.OperationRead); err != nil {
+		r.AbortWithError(storageErrorStatus(err), err)
+		return
+	}
+	if !hasThumbnail(fileInfo) {
+		r.AbortWithStatus(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	thumbnailConfig := config.GetConfig().Thumbnail
+	maxFileSize, err := config.ParseSize(thumbnailConfig.MaxFileSize)
+	if err != nil {
+		log.Printf("Invalid maximum file size for thumbnails: %s", err)
+		maxFileSize = 50 << 20
+	}
+	if maxFileSize > 0 && fileInfo.Size() > maxFileSize {
+		r.AbortWithStatus(http.StatusUnsupportedMediaType)
+		return
+	}
+	size := thumbnailConfig.Size
+	if size <= 0 {
+		log.Printf("Invalid thumbnail size: %d", size)
+		size = 256
+	}
+
+	// The thumbnail changes with the configured size, which the modification
+	// time of the image can't tell, so only the entity tag is sent
+	etag := thumbnailETag(fileInfo, size)
+	r.setValidators(etag, time.Time{})
+	if r.isNotModified(etag, time.Time{}) {
+		r.Status(http.StatusNotModified)
+		return
+	}
+
+	store := getThumbnailStore(thumbnailConfig)
+	name := r.thumbnailName(relpath, fileInfo, size)
+	if store != nil {
+		if f := store.Open(name); f != nil {
+			defer f.Close()
+			r.serveThumbnailFile(f)
+			return
+		}
+	}
+
+	// The slot is taken before retrieving the image, so no more images than
+	// slots are held in memory
+	select {
+	case thumbnailSlots <- struct{}{}:
+	case <-r.Request.Context().Done():
+		return
+	}
+	buf := new(bytes.Buffer)
+	if err := r.storage.Retrieve(relpath, buf); err != nil {
+		<-thumbnailSlots
+		r.AbortWithError(storageErrorStatus(err), err)
+		return
+	}
+	thumb := new(bytes.Buffer)
+	err = thumbnail.Make(buf.Bytes(), thumb, size, thumbnailConfig.MaxPixels)
+	<-thumbnailSlots
+	if err != nil {
+		if !errors.Is(err, thumbnail.ErrTooLarge) {
+			log.Printf("Making thumbnail of %s threw an error: %s", relpath, err)
+		}
+		r.AbortWithError(http.StatusUnsupportedMediaType, err)
+		return
+	}
+
+	if store != nil {
+		if err := cacheThumbnail(store, name, thumb.Bytes()); err != nil {
+			log.Printf("Caching thumbnail threw an error: %s", err.Error())
+		}
+	}
+	r.Data(http.StatusOK, "image/jpeg", thumb.Bytes())
+}
+
+// serveThumbnailFile serves a cached thumbnail.
+func (r *request) serveThumbnailFile(f *os.File) {
+	r.Header("content-type", "image/jpeg")
+	if info, err := f.Stat(); err == nil {
+		r.Header("content-length", fmt.Sprint(info.Size()))
+	}
+	r.Status(http.StatusOK)
+	if r.Request.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(r.Writer, f); err != nil {
+		r.Error(err)
+	}
+}
+
+// cacheThumbnail puts a thumbnail into the store.
+func cacheThumbnail(store *filecache.Store, name string, thumb []byte) error {
+	f, err := store.Create()
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(thumb); err != nil {
+		store.Discard(f)
+		return err
+	}
+	return store.Commit(f, name)
+}
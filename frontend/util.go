@@ -1,10 +1,19 @@
 package frontend
 
 import (
+	"bytes"
 	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
 	"strings"
 )
 
+var errUnsatisfiableRange = errors.New("unsatisfiable range")
+
 // parseBasicAuth parses an HTTP Basic Authentication string.
 // "Basic QWxhZGRpbjpvcGVuIHNlc2FtZQ==" returns ("Aladdin", "open sesame", true).
 func parseBasicAuth(auth string) (username, password string, ok bool) {
@@ -24,3 +33,118 @@ func parseBasicAuth(auth string) (username, password string, ok bool) {
 	}
 	return cs[:s], cs[s+1:], true
 }
+
+// storageErrorStatus returns the HTTP status code matching an error returned
+// by a storage.
+func storageErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, os.ErrPermission):
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+// isSameOrigin returns whether a request was sent from a page served by this
+// server. Requests that do not tell where they come from are refused, as
+// there is no telling whether another site made the browser send them.
+func isSameOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if len(origin) == 0 {
+		origin = req.Header.Get("Referer")
+	}
+	if len(origin) == 0 {
+		return false
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return u.Host == req.Host
+}
+
+// byteRange is a part of a file requested using a Range header.
+type byteRange struct {
+	offset, length int64
+}
+
+// parseRange parses the value of a Range header for a file of the given
+// size. Only single ranges are supported, other headers are ignored by
+// returning ok = false. Ranges lying outside of the file cause
+// errUnsatisfiableRange to be returned.
+func parseRange(header string, size int64) (r byteRange, ok bool, err error) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(header, prefix) {
+		return
+	}
+	spec := strings.TrimSpace(header[len(prefix):])
+	if strings.Contains(spec, ",") {
+		return
+	}
+	start, end, found := strings.Cut(spec, "-")
+	if !found {
+		return
+	}
+	start, end = strings.TrimSpace(start), strings.TrimSpace(end)
+
+	if len(start) == 0 {
+		// Suffix range, asking for the last bytes of the file
+		suffixLength, parseErr := strconv.ParseInt(end, 10, 64)
+		if parseErr != nil || suffixLength < 0 {
+			return
+		}
+		if suffixLength == 0 || size == 0 {
+			err = errUnsatisfiableRange
+			return
+		}
+		if suffixLength > size {
+			suffixLength = size
+		}
+		return byteRange{offset: size - suffixLength, length: suffixLength}, true, nil
+	}
+
+	offset, parseErr := strconv.ParseInt(start, 10, 64)
+	if parseErr != nil || offset < 0 {
+		return
+	}
+	if offset >= size {
+		err = errUnsatisfiableRange
+		return
+	}
+	last := size - 1
+	if len(end) > 0 {
+		last, parseErr = strconv.ParseInt(end, 10, 64)
+		if parseErr != nil || last < offset {
+			return byteRange{}, false, nil
+		}
+		if last >= size {
+			last = size - 1
+		}
+	}
+	return byteRange{offset: offset, length: last - offset + 1}, true, nil
+}
+
+// bufferResponseWriter collects a response in memory.
+type bufferResponseWriter struct {
+	bytes.Buffer
+	header http.Header
+}
+
+func (w *bufferResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferResponseWriter) WriteHeader(int) {}
+
+// countWriter counts the bytes written to another writer.
+type countWriter struct {
+	w     io.Writer
+	count int64
+}
+
+func (w *countWriter) Write(p []byte) (n int, err error) {
+	n, err = w.w.Write(p)
+	w.count += int64(n)
+	return
+}
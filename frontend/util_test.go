@@ -0,0 +1,68 @@
+package frontend
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsSameOrigin(t *testing.T) {
+	for _, test := range []struct {
+		origin, referer string
+		want            bool
+	}{
+		{"", "", false},
+		{"null", "", false},
+		{"http://example.com", "", true},
+		{"http://evil.example", "", false},
+		{"", "http://example.com/docs/", true},
+		{"", "http://evil.example/example.com", false},
+		{"http://evil.example", "http://example.com/", false},
+	} {
+		req := httptest.NewRequest("POST", "http://example.com/docs/", nil)
+		if len(test.origin) > 0 {
+			req.Header.Set("Origin", test.origin)
+		}
+		if len(test.referer) > 0 {
+			req.Header.Set("Referer", test.referer)
+		}
+		if got := isSameOrigin(req); got != test.want {
+			t.Errorf("isSameOrigin with Origin %q and Referer %q = %v, want %v",
+				test.origin, test.referer, got, test.want)
+		}
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	for _, test := range []struct {
+		header string
+		size   int64
+		want   byteRange
+		ok     bool
+		err    error
+	}{
+		{"bytes=0-99", 1000, byteRange{0, 100}, true, nil},
+		{"bytes=100-", 1000, byteRange{100, 900}, true, nil},
+		{"bytes=900-2000", 1000, byteRange{900, 100}, true, nil},
+		{"bytes=-100", 1000, byteRange{900, 100}, true, nil},
+		{"bytes=-2000", 1000, byteRange{0, 1000}, true, nil},
+		{"bytes= 5 - 9 ", 1000, byteRange{5, 5}, true, nil},
+		{"bytes=999-999", 1000, byteRange{999, 1}, true, nil},
+		{"bytes=1000-", 1000, byteRange{}, false, errUnsatisfiableRange},
+		{"bytes=-0", 1000, byteRange{}, false, errUnsatisfiableRange},
+		{"bytes=-5", 0, byteRange{}, false, errUnsatisfiableRange},
+		{"bytes=0-", 0, byteRange{}, false, errUnsatisfiableRange},
+		{"bytes=0-1,5-6", 1000, byteRange{}, false, nil},
+		{"bytes=5-1", 1000, byteRange{}, false, nil},
+		{"bytes=a-b", 1000, byteRange{}, false, nil},
+		{"bytes=-1-2", 1000, byteRange{}, false, nil},
+		{"bytes=5", 1000, byteRange{}, false, nil},
+		{"items=0-1", 1000, byteRange{}, false, nil},
+		{"", 1000, byteRange{}, false, nil},
+	} {
+		got, ok, err := parseRange(test.header, test.size)
+		if got != test.want || ok != test.ok || err != test.err {
+			t.Errorf("parseRange(%q, %d) = %v, %v, %v, want %v, %v, %v",
+				test.header, test.size, got, ok, err, test.want, test.ok, test.err)
+		}
+	}
+}
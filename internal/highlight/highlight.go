@@ -0,0 +1,388 @@
+// Package highlight marks up source code for display in HTML. It only knows
+// about comments, strings, numbers and keywords, which is enough to make code
+// easier to read without having to understand every language.
+package highlight
+
+import (
+	"html"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Classes of the spans wrapped around highlighted tokens.
+const (
+	ClassComment = "hl-c"
+	ClassString  = "hl-s"
+	ClassNumber  = "hl-n"
+	ClassKeyword = "hl-k"
+)
+
+// Language describes the syntax of a programming language as far as it is
+// highlighted.
+type Language struct {
+	Name string
+
+	LineComments  []string
+	BlockComments [][2]string
+	// Quotes lists the characters strings are enclosed in. Backslashes escape
+	// quotes, except in raw strings.
+	Quotes    string
+	RawQuotes string
+	Keywords  []string
+	// CaseInsensitive languages match keywords regardless of case
+	CaseInsensitive bool
+}
+
+var (
+	cKeywords = []string{
+		"auto", "break", "case", "char", "const", "continue", "default", "do",
+		"double", "else", "enum", "extern", "float", "for", "goto", "if",
+		"inline", "int", "long", "register", "return", "short", "signed",
+		"sizeof", "static", "struct", "switch", "typedef", "union",
+		"unsigned", "void", "volatile", "while", "NULL", "#include",
+		"#define", "#ifdef", "#ifndef", "#endif", "#if", "#else",
+	}
+	cppKeywords = append([]string{
+		"bool", "catch", "class", "delete", "false", "namespace", "new",
+		"nullptr", "operator", "private", "protected", "public", "template",
+		"this", "throw", "true", "try", "typename", "using", "virtual",
+	}, cKeywords...)
+	javaKeywords = []string{
+		"abstract", "boolean", "break", "byte", "case", "catch", "char",
+		"class", "continue", "default", "do", "double", "else", "enum",
+		"extends", "false", "final", "finally", "float", "for", "if",
+		"implements", "import", "instanceof", "int", "interface", "long",
+		"new", "null", "package", "private", "protected", "public", "return",
+		"short", "static", "super", "switch", "this", "throw", "throws",
+		"true", "try", "void", "while", "val", "var", "fun", "override",
+	}
+	jsKeywords = []string{
+		"async", "await", "break", "case", "catch", "class", "const",
+		"continue", "default", "delete", "do", "else", "export", "extends",
+		"false", "finally", "for", "from", "function", "if", "import", "in",
+		"instanceof", "interface", "let", "new", "null", "of", "return",
+		"static", "super", "switch", "this", "throw", "true", "try", "type",
+		"typeof", "undefined", "var", "void", "while", "yield",
+	}
+	shellKeywords = []string{
+		"case", "do", "done", "elif", "else", "esac", "exit", "export", "fi",
+		"for", "function", "if", "in", "local", "return", "then", "until",
+		"while",
+	}
+)
+
+// languages lists all known languages by the extensions of their files.
+var languages = map[string]*Language{}
+
+func register(lang *Language, extensions ...string) {
+	for _, ext := range extensions {
+		languages[ext] = lang
+	}
+}
+
+func init() {
+	register(&Language{
+		Name:          "go",
+		LineComments:  []string{"//"},
+		BlockComments: [][2]string{{"/*", "*/"}},
+		Quotes:        `"'`,
+		RawQuotes:     "`",
+		Keywords: []string{
+			"break", "case", "chan", "const", "continue", "default", "defer",
+			"else", "fallthrough", "false", "for", "func", "go", "goto", "if",
+			"import", "interface", "map", "nil", "package", "range",
+			"return", "select", "struct", "switch", "true", "type", "var",
+		},
+	}, ".go")
+	register(&Language{
+		Name:          "c",
+		LineComments:  []string{"//"},
+		BlockComments: [][2]string{{"/*", "*/"}},
+		Quotes:        `"'`,
+		Keywords:      cKeywords,
+	}, ".c", ".h")
+	register(&Language{
+		Name:          "cpp",
+		LineComments:  []string{"//"},
+		BlockComments: [][2]string{{"/*", "*/"}},
+		Quotes:        `"'`,
+		Keywords:      cppKeywords,
+	}, ".cc", ".cpp", ".cxx", ".hh", ".hpp", ".hxx")
+	register(&Language{
+		Name:          "java",
+		LineComments:  []string{"//"},
+		BlockComments: [][2]string{{"/*", "*/"}},
+		Quotes:        `"'`,
+		Keywords:      javaKeywords,
+	}, ".java", ".kt", ".kts", ".scala", ".cs")
+	register(&Language{
+		Name:          "javascript",
+		LineComments:  []string{"//"},
+		BlockComments: [][2]string{{"/*", "*/"}},
+		Quotes:        `"'`,
+		RawQuotes:     "`",
+		Keywords:      jsKeywords,
+	}, ".js", ".mjs", ".cjs", ".jsx", ".ts", ".tsx")
+	register(&Language{
+		Name:         "python",
+		LineComments: []string{"#"},
+		Quotes:       `"'`,
+		Keywords: []string{
+			"and", "as", "assert", "async", "await", "break", "class",
+			"continue", "def", "del", "elif", "else", "except", "False",
+			"finally", "for", "from", "global", "if", "import", "in", "is",
+			"lambda", "None", "nonlocal", "not", "or", "pass", "raise",
+			"return", "True", "try", "while", "with", "yield",
+		},
+	}, ".py", ".pyw")
+	register(&Language{
+		Name:         "ruby",
+		LineComments: []string{"#"},
+		Quotes:       `"'`,
+		Keywords: []string{
+			"begin", "class", "def", "do", "else", "elsif", "end", "ensure",
+			"false", "if", "module", "nil", "require", "rescue", "return",
+			"self", "then", "true", "unless", "until", "when", "while",
+			"yield",
+		},
+	}, ".rb")
+	register(&Language{
+		Name:          "rust",
+		LineComments:  []string{"//"},
+		BlockComments: [][2]string{{"/*", "*/"}},
+		Quotes:        `"`,
+		Keywords: []string{
+			"as", "break", "const", "continue", "crate", "else", "enum",
+			"extern", "false", "fn", "for", "if", "impl", "in", "let", "loop",
+			"match", "mod", "move", "mut", "pub", "ref", "return", "self",
+			"Self", "static", "struct", "super", "trait", "true", "type",
+			"unsafe", "use", "where", "while",
+		},
+	}, ".rs")
+	register(&Language{
+		Name:          "php",
+		LineComments:  []string{"//", "#"},
+		BlockComments: [][2]string{{"/*", "*/"}},
+		Quotes:        `"'`,
+		Keywords: []string{
+			"abstract", "array", "as", "break", "case", "catch", "class",
+			"const", "continue", "default", "do", "echo", "else", "elseif",
+			"extends", "false", "final", "for", "foreach", "function", "if",
+			"implements", "include", "interface", "namespace", "new", "null",
+			"private", "protected", "public", "require", "return", "static",
+			"switch", "throw", "true", "try", "use", "while",
+		},
+	}, ".php")
+	register(&Language{
+		Name:         "shell",
+		LineComments: []string{"#"},
+		Quotes:       `"`,
+		RawQuotes:    "'",
+		Keywords:     shellKeywords,
+	}, ".sh", ".bash", ".zsh")
+	register(&Language{
+		Name:          "sql",
+		LineComments:  []string{"--"},
+		BlockComments: [][2]string{{"/*", "*/"}},
+		Quotes:        `"'`,
+		Keywords: []string{
+			"alter", "and", "as", "by", "create", "delete", "drop", "from",
+			"group", "having", "index", "insert", "into", "join", "key",
+			"left", "limit", "not", "null", "on", "or", "order", "primary",
+			"right", "select", "set", "table", "update", "values", "where",
+		},
+		CaseInsensitive: true,
+	}, ".sql")
+	register(&Language{
+		Name:          "css",
+		BlockComments: [][2]string{{"/*", "*/"}},
+		Quotes:        `"'`,
+	}, ".css", ".scss", ".less")
+	register(&Language{
+		Name:          "markup",
+		BlockComments: [][2]string{{"<!--", "-->"}},
+		// Apostrophes are too common in text to start strings
+		Quotes: `"`,
+	}, ".html", ".htm", ".xml", ".svg", ".xhtml")
+	register(&Language{
+		Name:   "json",
+		Quotes: `"`,
+		Keywords: []string{
+			"true", "false", "null",
+		},
+	}, ".json")
+	register(&Language{
+		Name:         "config",
+		LineComments: []string{"#", ";"},
+		Quotes:       `"'`,
+		Keywords: []string{
+			"true", "false", "yes", "no", "on", "off", "null",
+		},
+	}, ".yml", ".yaml", ".toml", ".ini", ".cfg", ".conf", ".properties")
+}
+
+// LanguageOf returns the language of a file as guessed from its name, or nil
+// if it is not known.
+func LanguageOf(name string) *Language {
+	return languages[strings.ToLower(path.Ext(name))]
+}
+
+// LanguageByName returns the language with the given name or one of its
+// extensions, as used e.g. to mark code blocks in Markdown. If it is not
+// known, nil is returned.
+func LanguageByName(name string) *Language {
+	name = strings.ToLower(name)
+	if lang, ok := languages["."+name]; ok {
+		return lang
+	}
+	for _, lang := range languages {
+		if lang.Name == name {
+			return lang
+		}
+	}
+	return nil
+}
+
+// Highlight returns the code as HTML, with tokens wrapped in spans. If the
+// language is nil, the code is only escaped.
+func Highlight(code string, lang *Language) string {
+	if lang == nil {
+		return html.EscapeString(code)
+	}
+
+	var b strings.Builder
+	span := func(class, text string) {
+		b.WriteString(`<span class="` + class + `">`)
+		b.WriteString(html.EscapeString(text))
+		b.WriteString(`</span>`)
+	}
+
+	keywords := make(map[string]bool, len(lang.Keywords))
+	for _, keyword := range lang.Keywords {
+		if lang.CaseInsensitive {
+			keyword = strings.ToLower(keyword)
+		}
+		keywords[keyword] = true
+	}
+
+	atWordStart := true
+	for i := 0; i < len(code); {
+		rest := code[i:]
+
+		if n := commentLength(rest, lang); n > 0 {
+			span(ClassComment, rest[:n])
+			i += n
+			atWordStart = true
+			continue
+		}
+
+		c := rest[0]
+		if strings.IndexByte(lang.Quotes, c) >= 0 || strings.IndexByte(lang.RawQuotes, c) >= 0 {
+			n := stringLength(rest, strings.IndexByte(lang.RawQuotes, c) >= 0)
+			span(ClassString, rest[:n])
+			i += n
+			atWordStart = true
+			continue
+		}
+
+		if atWordStart && c >= '0' && c <= '9' {
+			n := numberLength(rest)
+			span(ClassNumber, rest[:n])
+			i += n
+			atWordStart = false
+			continue
+		}
+
+		if atWordStart && (isWordByte(c) || c == '#') {
+			n := 1 + wordLength(rest[1:])
+			word := rest[:n]
+			lookup := word
+			if lang.CaseInsensitive {
+				lookup = strings.ToLower(word)
+			}
+			if keywords[lookup] {
+				span(ClassKeyword, word)
+			} else {
+				b.WriteString(html.EscapeString(word))
+			}
+			i += n
+			atWordStart = false
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(rest)
+		b.WriteString(html.EscapeString(rest[:size]))
+		i += size
+		atWordStart = !isWordRune(r)
+	}
+	return b.String()
+}
+
+// commentLength returns the length of the comment code starts with, or 0.
+func commentLength(code string, lang *Language) int {
+	for _, start := range lang.LineComments {
+		if strings.HasPrefix(code, start) {
+			if end := strings.IndexByte(code, '\n'); end >= 0 {
+				return end
+			}
+			return len(code)
+		}
+	}
+	for _, delimiters := range lang.BlockComments {
+		if strings.HasPrefix(code, delimiters[0]) {
+			if end := strings.Index(code[len(delimiters[0]):], delimiters[1]); end >= 0 {
+				return len(delimiters[0]) + end + len(delimiters[1])
+			}
+			return len(code)
+		}
+	}
+	return 0
+}
+
+// stringLength returns the length of the string literal code starts with.
+// Strings that are not raw end at the end of the line at the latest.
+func stringLength(code string, raw bool) int {
+	quote := code[0]
+	for i := 1; i < len(code); i++ {
+		switch {
+		case code[i] == quote:
+			return i + 1
+		case !raw && code[i] == '\\':
+			i++
+		case !raw && code[i] == '\n':
+			return i
+		}
+	}
+	return len(code)
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// wordLength returns the length of the word code starts with.
+func wordLength(code string) int {
+	for i := 0; i < len(code); i++ {
+		if !isWordByte(code[i]) {
+			return i
+		}
+	}
+	return len(code)
+}
+
+// numberLength returns the length of the number code starts with, including
+// decimal points, exponents and suffixes.
+func numberLength(code string) int {
+	for i := 0; i < len(code); i++ {
+		if !isWordByte(code[i]) && code[i] != '.' {
+			return i
+		}
+	}
+	return len(code)
+}
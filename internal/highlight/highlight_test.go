@@ -0,0 +1,67 @@
+package highlight
+
+import "testing"
+
+func TestHighlight(t *testing.T) {
+	for _, test := range []struct {
+		code string
+		lang *Language
+		want string
+	}{
+		{
+			"func f() string { return \"a\\\"b\" } // c\n/* d */ x := 0x1F",
+			LanguageByName("go"),
+			`<span class="hl-k">func</span> f() string { <span class="hl-k">return</span> ` +
+				`<span class="hl-s">&#34;a\&#34;b&#34;</span> } <span class="hl-c">// c</span>` + "\n" +
+				`<span class="hl-c">/* d */</span> x := <span class="hl-n">0x1F</span>`,
+		},
+		{
+			"SELECT * FROM t WHERE a = 'x' -- c",
+			LanguageOf("query.SQL"),
+			`<span class="hl-k">SELECT</span> * <span class="hl-k">FROM</span> t ` +
+				`<span class="hl-k">WHERE</span> a = <span class="hl-s">&#39;x&#39;</span> ` +
+				`<span class="hl-c">-- c</span>`,
+		},
+		{
+			`<a href="x">it's</a><!-- c -->`,
+			LanguageOf("page.html"),
+			`&lt;a href=<span class="hl-s">&#34;x&#34;</span>&gt;it&#39;s&lt;/a&gt;` +
+				`<span class="hl-c">&lt;!-- c --&gt;</span>`,
+		},
+		{
+			// Strings end at the end of the line, comments at the end of
+			// the code
+			"\"open\nx1 /* open",
+			LanguageByName("c"),
+			`<span class="hl-s">&#34;open</span>` + "\n" + `x1 <span class="hl-c">/* open</span>`,
+		},
+		{
+			"<b>",
+			nil,
+			"&lt;b&gt;",
+		},
+	} {
+		if got := Highlight(test.code, test.lang); got != test.want {
+			t.Errorf("Highlight(%q) =\n%q\nwant\n%q", test.code, got, test.want)
+		}
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	for _, test := range []struct {
+		lang *Language
+		want string
+	}{
+		{LanguageOf("main.go"), "go"},
+		{LanguageOf("dir.d/Script.PY"), "python"},
+		{LanguageByName("yml"), "config"},
+		{LanguageByName("Go"), "go"},
+	} {
+		if test.lang == nil || test.lang.Name != test.want {
+			t.Errorf("got language %v, want %s", test.lang, test.want)
+		}
+	}
+	if lang := LanguageOf("notes.unknown"); lang != nil {
+		t.Errorf("got language %s for unknown extension", lang.Name)
+	}
+}
@@ -0,0 +1,812 @@
+// Package markdown renders the commonly used parts of Markdown to HTML. Raw
+// HTML is escaped instead of passed through and only harmless link targets
+// are kept, so the output is safe to embed in pages.
+package markdown
+
+import (
+	"html"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/kthxat/filament/internal/highlight"
+)
+
+// Options change how Markdown is rendered.
+type Options struct {
+	// LinkPrefix is put in front of relative link and image targets, which
+	// is needed if the document is shown at another location than where it
+	// is stored.
+	LinkPrefix string
+}
+
+// Render converts a Markdown document to HTML.
+func Render(src []byte, options *Options) string {
+	if options == nil {
+		options = &Options{}
+	}
+	r := &renderer{options: options}
+
+	text := strings.ReplaceAll(string(src), "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = expandTabs(line)
+	}
+
+	r.blocks(lines, false)
+	return r.b.String()
+}
+
+type renderer struct {
+	b       strings.Builder
+	options *Options
+
+	// nesting is how many inline elements the text being rendered is
+	// located in
+	nesting int
+}
+
+const (
+	// maxNesting limits how deeply emphasis and links may be nested in each
+	// other. Deeper markup is shown as it is, so rendering stays fast.
+	maxNesting = 16
+	// maxLinkLength limits how far after its text the destination and title
+	// of a link are looked for.
+	maxLinkLength = 2048
+)
+
+// expandTabs replaces tabs in the indentation of a line by spaces.
+func expandTabs(line string) string {
+	if !strings.Contains(line, "\t") {
+		return line
+	}
+	var b strings.Builder
+	for i, c := range line {
+		if c == '\t' {
+			b.WriteString(strings.Repeat(" ", 4-b.Len()%4))
+		} else if c == ' ' {
+			b.WriteByte(' ')
+		} else {
+			b.WriteString(line[i:])
+			break
+		}
+	}
+	return b.String()
+}
+
+// indentation returns the number of spaces a line starts with.
+func indentation(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// fence returns the fence a line opens or closes a fenced code block with.
+func fence(line string) string {
+	if indentation(line) > 3 {
+		return ""
+	}
+	trimmed := strings.TrimLeft(line, " ")
+	for _, c := range []string{"`", "~"} {
+		if strings.HasPrefix(trimmed, c+c+c) {
+			return trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, c))]
+		}
+	}
+	return ""
+}
+
+// heading returns the level and text of an ATX heading.
+func heading(line string) (level int, text string) {
+	if indentation(line) > 3 {
+		return 0, ""
+	}
+	trimmed := strings.TrimLeft(line, " ")
+	level = len(trimmed) - len(strings.TrimLeft(trimmed, "#"))
+	if level == 0 || level > 6 {
+		return 0, ""
+	}
+	text = trimmed[level:]
+	if len(text) > 0 && text[0] != ' ' {
+		return 0, ""
+	}
+	text = strings.TrimSpace(text)
+	// Closing sequences are optional
+	if closed := strings.TrimRight(text, "#"); len(closed) == 0 || strings.HasSuffix(closed, " ") {
+		text = strings.TrimSpace(closed)
+	}
+	return level, text
+}
+
+// isRule returns whether a line is a thematic break.
+func isRule(line string) bool {
+	if indentation(line) > 3 {
+		return false
+	}
+	compact := strings.ReplaceAll(strings.TrimSpace(line), " ", "")
+	if len(compact) < 3 {
+		return false
+	}
+	for _, c := range []string{"-", "*", "_"} {
+		if strings.Trim(compact, c) == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// setextLevel returns the level of the heading a line underlines.
+func setextLevel(line string) int {
+	if indentation(line) > 3 {
+		return 0
+	}
+	trimmed := strings.TrimSpace(line)
+	switch {
+	case len(trimmed) > 0 && strings.Trim(trimmed, "=") == "":
+		return 1
+	case len(trimmed) > 0 && strings.Trim(trimmed, "-") == "":
+		return 2
+	}
+	return 0
+}
+
+func isQuote(line string) bool {
+	return indentation(line) <= 3 && strings.HasPrefix(strings.TrimLeft(line, " "), ">")
+}
+
+// listMarker parses the marker a list item starts with. contentIndent is
+// where the content of the item starts.
+func listMarker(line string) (ok, ordered bool, start, contentIndent int) {
+	indent := indentation(line)
+	if indent > 3 {
+		return
+	}
+	rest := line[indent:]
+
+	markerLength := 0
+	switch {
+	case len(rest) > 0 && strings.IndexByte("-*+", rest[0]) >= 0:
+		markerLength = 1
+	default:
+		digits := len(rest) - len(strings.TrimLeft(rest, "0123456789"))
+		if digits == 0 || digits > 9 || len(rest) <= digits || (rest[digits] != '.' && rest[digits] != ')') {
+			return
+		}
+		ordered = true
+		start, _ = strconv.Atoi(rest[:digits])
+		markerLength = digits + 1
+	}
+
+	after := rest[markerLength:]
+	if len(after) > 0 && after[0] != ' ' {
+		ordered = false
+		return
+	}
+	spaces := indentation(after)
+	if spaces == 0 || spaces > 4 || isBlank(after) {
+		spaces = 1
+	}
+	return true, ordered, start, indent + markerLength + spaces
+}
+
+func isListItem(line string) bool {
+	ok, _, _, _ := listMarker(line)
+	return ok
+}
+
+// startsBlock returns whether a line interrupts a paragraph.
+func startsBlock(line string) bool {
+	if level, _ := heading(line); level > 0 {
+		return true
+	}
+	// Only lists that are not empty and, if ordered, start with 1 interrupt
+	// paragraphs
+	if ok, ordered, start, contentIndent := listMarker(line); ok &&
+		contentIndent < len(line) && !isBlank(line[contentIndent:]) && (!ordered || start == 1) {
+		return true
+	}
+	return len(fence(line)) > 0 || isRule(line) || isQuote(line)
+}
+
+// blocks renders a sequence of lines. In tight lists, paragraphs are not
+// wrapped in p elements.
+func (r *renderer) blocks(lines []string, tight bool) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+
+		switch {
+		case isBlank(line):
+			i++
+
+		case len(fence(line)) > 0:
+			i = r.fencedCode(lines, i)
+
+		case isRule(line):
+			r.b.WriteString("<hr />\n")
+			i++
+
+		case isQuote(line):
+			var quoted []string
+			for ; i < len(lines) && isQuote(lines[i]); i++ {
+				q := strings.TrimPrefix(strings.TrimLeft(lines[i], " "), ">")
+				quoted = append(quoted, strings.TrimPrefix(q, " "))
+			}
+			r.b.WriteString("<blockquote>\n")
+			r.blocks(quoted, false)
+			r.b.WriteString("</blockquote>\n")
+
+		case indentation(line) >= 4:
+			var code []string
+			for ; i < len(lines) && (indentation(lines[i]) >= 4 || isBlank(lines[i])); i++ {
+				if len(lines[i]) >= 4 {
+					code = append(code, lines[i][4:])
+				} else {
+					code = append(code, "")
+				}
+			}
+			for len(code) > 0 && code[len(code)-1] == "" {
+				code = code[:len(code)-1]
+			}
+			r.b.WriteString("<pre><code>")
+			r.b.WriteString(html.EscapeString(strings.Join(code, "\n")))
+			r.b.WriteString("</code></pre>\n")
+
+		default:
+			if level, text := heading(line); level > 0 {
+				r.heading(level, text)
+				i++
+				continue
+			}
+			if ok, _, _, _ := listMarker(line); ok {
+				i = r.list(lines, i)
+				continue
+			}
+			i = r.paragraph(lines, i, tight)
+		}
+	}
+}
+
+func (r *renderer) heading(level int, text string) {
+	tag := "h" + strconv.Itoa(level)
+	r.b.WriteString("<" + tag + ">")
+	r.inline(text)
+	r.b.WriteString("</" + tag + ">\n")
+}
+
+// fencedCode renders the fenced code block starting at line i and returns
+// the index of the line after it.
+func (r *renderer) fencedCode(lines []string, i int) int {
+	opening := fence(lines[i])
+	info := strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(lines[i]), opening[:1]))
+	indent := indentation(lines[i])
+
+	var code []string
+	for i++; i < len(lines); i++ {
+		if closing := fence(lines[i]); len(closing) >= len(opening) && closing[0] == opening[0] &&
+			isBlank(strings.TrimLeft(strings.TrimSpace(lines[i]), closing[:1])) {
+			i++
+			break
+		}
+		line := lines[i]
+		if strip := indentation(line); strip > 0 {
+			if strip > indent {
+				strip = indent
+			}
+			line = line[strip:]
+		}
+		code = append(code, line)
+	}
+
+	var lang *highlight.Language
+	if fields := strings.Fields(info); len(fields) > 0 {
+		lang = highlight.LanguageByName(fields[0])
+	}
+	r.b.WriteString("<pre><code>")
+	r.b.WriteString(highlight.Highlight(strings.Join(code, "\n"), lang))
+	r.b.WriteString("</code></pre>\n")
+	return i
+}
+
+// paragraph renders the paragraph starting at line i, which may turn out to
+// be a setext heading, and returns the index of the line after it.
+func (r *renderer) paragraph(lines []string, i int, tight bool) int {
+	text := []string{strings.TrimLeft(lines[i], " ")}
+	for i++; i < len(lines); i++ {
+		line := lines[i]
+		if level := setextLevel(line); level > 0 && !(level == 2 && len(text) == 0) {
+			r.heading(level, strings.Join(text, "\n"))
+			return i + 1
+		}
+		if isBlank(line) || startsBlock(line) {
+			break
+		}
+		text = append(text, strings.TrimLeft(line, " "))
+	}
+
+	if !tight {
+		r.b.WriteString("<p>")
+	}
+	r.inline(strings.Join(text, "\n"))
+	if !tight {
+		r.b.WriteString("</p>")
+	}
+	r.b.WriteString("\n")
+	return i
+}
+
+// list renders the list starting at line i and returns the index of the line
+// after it.
+func (r *renderer) list(lines []string, i int) int {
+	_, ordered, start, _ := listMarker(lines[i])
+
+	var items [][]string
+	tight := true
+	for i < len(lines) {
+		ok, itemOrdered, _, contentIndent := listMarker(lines[i])
+		if !ok || itemOrdered != ordered {
+			break
+		}
+
+		item := []string{lines[i][min(contentIndent, len(lines[i])):]}
+		blank := false
+		for i++; i < len(lines); i++ {
+			line := lines[i]
+			switch {
+			case isBlank(line):
+				blank = true
+				item = append(item, "")
+				continue
+			case indentation(line) >= contentIndent:
+				if blank {
+					blank = false
+					tight = tight && endsWithList(item)
+				}
+				item = append(item, line[contentIndent:])
+				continue
+			case indentation(line) < contentIndent && isListItem(line):
+				// The next item
+			case !blank && !startsBlock(line):
+				// Lazy continuation of a paragraph
+				item = append(item, line)
+				continue
+			}
+			break
+		}
+
+		for len(item) > 0 && item[len(item)-1] == "" {
+			item = item[:len(item)-1]
+		}
+		items = append(items, item)
+
+		if i < len(lines) && blank {
+			if ok, itemOrdered, _, _ := listMarker(lines[i]); ok && itemOrdered == ordered {
+				tight = false
+				continue
+			}
+			break
+		}
+	}
+
+	tag := "ul"
+	if ordered {
+		tag = "ol"
+	}
+	r.b.WriteString("<" + tag)
+	if ordered && start != 1 {
+		r.b.WriteString(` start="` + strconv.Itoa(start) + `"`)
+	}
+	r.b.WriteString(">\n")
+	for _, item := range items {
+		r.b.WriteString("<li>")
+		r.blocks(item, tight)
+		r.b.WriteString("</li>\n")
+	}
+	r.b.WriteString("</" + tag + ">\n")
+	return i
+}
+
+// endsWithList returns whether the last block of an item is a nested list,
+// which may be separated by blank lines without making the list loose.
+func endsWithList(item []string) bool {
+	for j := len(item) - 1; j >= 0; j-- {
+		if isBlank(item[j]) {
+			continue
+		}
+		ok, _, _, _ := listMarker(item[j])
+		return ok || indentation(item[j]) > 0
+	}
+	return false
+}
+
+// inline renders the text of a paragraph or heading.
+func (r *renderer) inline(text string) {
+	r.nesting++
+	defer func() { r.nesting-- }()
+	if r.nesting > maxNesting {
+		r.b.WriteString(html.EscapeString(text))
+		return
+	}
+
+	// Looking for the end of delimiters that were not closed before is in
+	// vain, remembering them keeps rendering from taking quadratic time
+	unclosed := map[string]bool{}
+	var brackets map[int]int
+
+	atWordStart := true
+	for i := 0; i < len(text); {
+		c := text[i]
+		rest := text[i:]
+
+		switch {
+		case c == '\\' && i+1 < len(text) && isPunctuation(text[i+1]):
+			r.b.WriteString(html.EscapeString(text[i+1 : i+2]))
+			i += 2
+			atWordStart = false
+			continue
+
+		case c == '\\' && i+1 < len(text) && text[i+1] == '\n':
+			r.b.WriteString("<br />\n")
+			i += 2
+			atWordStart = true
+			continue
+
+		case c == '\n':
+			if strings.HasSuffix(text[:i], "  ") {
+				r.b.WriteString("<br />")
+			}
+			r.b.WriteByte('\n')
+			i++
+			atWordStart = true
+			continue
+
+		case c == '`':
+			if n := r.codeSpan(rest, unclosed); n > 0 {
+				i += n
+				atWordStart = false
+				continue
+			}
+			ticks := len(rest) - len(strings.TrimLeft(rest, "`"))
+			r.b.WriteString(rest[:ticks])
+			i += ticks
+			atWordStart = false
+			continue
+
+		case c == '!' && strings.HasPrefix(rest, "!["):
+			if brackets == nil {
+				brackets = matchBrackets(text)
+			}
+			if closing, ok := brackets[i+1]; ok {
+				if n := r.link(rest[1:], closing-i-1, true); n > 0 {
+					i += 1 + n
+					atWordStart = false
+					continue
+				}
+			}
+
+		case c == '[':
+			if brackets == nil {
+				brackets = matchBrackets(text)
+			}
+			if closing, ok := brackets[i]; ok {
+				if n := r.link(rest, closing-i, false); n > 0 {
+					i += n
+					atWordStart = false
+					continue
+				}
+			}
+
+		case c == '<':
+			if n := r.autolink(rest); n > 0 {
+				i += n
+				atWordStart = false
+				continue
+			}
+
+		case c == '*' || c == '_' || c == '~':
+			if n := r.emphasis(text, i, unclosed); n > 0 {
+				i += n
+				atWordStart = false
+				continue
+			}
+
+		case atWordStart && (strings.HasPrefix(rest, "http://") || strings.HasPrefix(rest, "https://")):
+			if n := r.bareLink(rest); n > 0 {
+				i += n
+				atWordStart = false
+				continue
+			}
+		}
+
+		ch, size := utf8.DecodeRuneInString(rest)
+		r.b.WriteString(html.EscapeString(rest[:size]))
+		i += size
+		atWordStart = !unicode.IsLetter(ch) && !unicode.IsDigit(ch)
+	}
+}
+
+func isPunctuation(c byte) bool {
+	return c < utf8.RuneSelf && unicode.IsPunct(rune(c)) || strings.IndexByte("$+<=>^`|~", c) >= 0
+}
+
+// codeSpan renders the code span text starts with and returns its length,
+// or 0 if it does not start with one. Delimiters without an end are added to
+// unclosed.
+func (r *renderer) codeSpan(text string, unclosed map[string]bool) int {
+	ticks := len(text) - len(strings.TrimLeft(text, "`"))
+	delimiter := text[:ticks]
+	if unclosed[delimiter] {
+		return 0
+	}
+	for j := ticks; j < len(text); {
+		k := strings.Index(text[j:], delimiter)
+		if k < 0 {
+			break
+		}
+		end := j + k
+		if end+ticks < len(text) && text[end+ticks] == '`' {
+			// Longer run of backticks, keep looking
+			j = end + ticks + len(text[end+ticks:]) - len(strings.TrimLeft(text[end+ticks:], "`"))
+			continue
+		}
+		code := strings.ReplaceAll(text[ticks:end], "\n", " ")
+		if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+			code = code[1 : len(code)-1]
+		}
+		r.b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+		return end + ticks
+	}
+	unclosed[delimiter] = true
+	return 0
+}
+
+// matchBrackets returns the positions of the brackets in text keyed by the
+// positions of the brackets they close.
+func matchBrackets(text string) map[int]int {
+	matches := map[int]int{}
+	var open []int
+	for j := 0; j < len(text); j++ {
+		switch text[j] {
+		case '\\':
+			j++
+		case '[':
+			open = append(open, j)
+		case ']':
+			if len(open) > 0 {
+				matches[open[len(open)-1]] = j
+				open = open[:len(open)-1]
+			}
+		}
+	}
+	return matches
+}
+
+// link renders the link or image text starts with and returns its length, or
+// 0 if it does not start with one. The text of the link ends with the bracket
+// at closing.
+func (r *renderer) link(text string, closing int, isImage bool) int {
+	if closing+1 >= len(text) || text[closing+1] != '(' {
+		return 0
+	}
+	label := text[1:closing]
+	if len(text) > closing+maxLinkLength {
+		text = text[:closing+maxLinkLength]
+	}
+
+	// Parse destination and optional title
+	j := closing + 2
+	for j < len(text) && text[j] == ' ' {
+		j++
+	}
+	destStart := j
+	var dest string
+	if j < len(text) && text[j] == '<' {
+		end := strings.IndexAny(text[j:], ">\n")
+		if end < 0 || text[j+end] != '>' {
+			return 0
+		}
+		dest = text[j+1 : j+end]
+		j += end + 1
+	} else {
+		parens := 0
+		for ; j < len(text); j++ {
+			if text[j] == '(' {
+				parens++
+			} else if text[j] == ')' {
+				if parens == 0 {
+					break
+				}
+				parens--
+			} else if text[j] == ' ' || text[j] == '\n' {
+				break
+			}
+		}
+		dest = text[destStart:j]
+	}
+	for j < len(text) && (text[j] == ' ' || text[j] == '\n') {
+		j++
+	}
+	title := ""
+	if j < len(text) && (text[j] == '"' || text[j] == '\'') {
+		end := strings.IndexByte(text[j+1:], text[j])
+		if end < 0 {
+			return 0
+		}
+		title = text[j+1 : j+1+end]
+		j += end + 2
+		for j < len(text) && text[j] == ' ' {
+			j++
+		}
+	}
+	if j >= len(text) || text[j] != ')' {
+		return 0
+	}
+
+	href, ok := r.url(dest)
+	switch {
+	case isImage && ok:
+		r.b.WriteString(`<img src="` + html.EscapeString(href) + `" alt="` + html.EscapeString(plainText(label)) + `"`)
+		if len(title) > 0 {
+			r.b.WriteString(` title="` + html.EscapeString(title) + `"`)
+		}
+		r.b.WriteString(" />")
+	case isImage:
+		r.b.WriteString(html.EscapeString(plainText(label)))
+	case ok:
+		r.b.WriteString(`<a href="` + html.EscapeString(href) + `"`)
+		if len(title) > 0 {
+			r.b.WriteString(` title="` + html.EscapeString(title) + `"`)
+		}
+		r.b.WriteString(">")
+		r.inline(label)
+		r.b.WriteString("</a>")
+	default:
+		r.inline(label)
+	}
+	return j + 1
+}
+
+// plainText strips the most common markup from text, for use as alternative
+// text of images.
+func plainText(text string) string {
+	return strings.NewReplacer("*", "", "_", "", "`", "", "\\", "").Replace(text)
+}
+
+// autolink renders the autolink text starts with, e.g. <https://example.com>,
+// and returns its length, or 0 if it does not start with one.
+func (r *renderer) autolink(text string) int {
+	end := strings.IndexAny(text, "> \n")
+	if end < 0 || text[end] != '>' {
+		return 0
+	}
+	target := text[1:end]
+	if strings.Contains(target, "@") && !strings.Contains(target, ":") {
+		target = "mailto:" + target
+	} else if !strings.Contains(target, ":") {
+		return 0
+	}
+	href, ok := r.url(target)
+	if !ok {
+		return 0
+	}
+	r.b.WriteString(`<a href="` + html.EscapeString(href) + `">` +
+		html.EscapeString(strings.TrimPrefix(text[1:end], "mailto:")) + "</a>")
+	return end + 1
+}
+
+// bareLink renders the web address text starts with as a link and returns
+// its length.
+func (r *renderer) bareLink(text string) int {
+	end := strings.IndexAny(text, " \n<")
+	if end < 0 {
+		end = len(text)
+	}
+	// Punctuation at the end most likely belongs to the sentence
+	target := strings.TrimRight(text[:end], ".,:;!?'\")*_")
+	href, ok := r.url(target)
+	if !ok || len(target) <= len("https://") {
+		return 0
+	}
+	r.b.WriteString(`<a href="` + html.EscapeString(href) + `">` + html.EscapeString(target) + "</a>")
+	return len(target)
+}
+
+// emphasis renders the emphasized text starting at text[i] and returns the
+// length of it, or 0 if there is none. Delimiters without a closing one are
+// added to unclosed.
+func (r *renderer) emphasis(text string, i int, unclosed map[string]bool) int {
+	c := text[i]
+	run := len(text[i:]) - len(strings.TrimLeft(text[i:], string(c)))
+	if c == '~' && run != 2 {
+		return 0
+	}
+	if run > 3 {
+		return 0
+	}
+	delimiter := text[i : i+run]
+
+	// Opening delimiters must be followed by text, and underscores must not
+	// be inside of words
+	if i+run >= len(text) || unicode.IsSpace(rune(text[i+run])) {
+		return 0
+	}
+	if c == '_' && i > 0 && isWordChar(text[:i], true) {
+		return 0
+	}
+	// Whether a delimiter closes another one does not depend on where the
+	// other one is, so no later one can be closed either
+	if unclosed[delimiter] {
+		return 0
+	}
+
+	for j := i + run; j < len(text); {
+		k := strings.Index(text[j:], delimiter)
+		if k < 0 {
+			break
+		}
+		end := j + k
+		after := end + run
+		if !unicode.IsSpace(rune(text[end-1])) && (after >= len(text) || text[after] != c) &&
+			!(c == '_' && after < len(text) && isWordChar(text[after:], false)) && end > i+run {
+			var open, close string
+			switch {
+			case c == '~':
+				open, close = "<del>", "</del>"
+			case run == 1:
+				open, close = "<em>", "</em>"
+			case run == 2:
+				open, close = "<strong>", "</strong>"
+			default:
+				open, close = "<strong><em>", "</em></strong>"
+			}
+			r.b.WriteString(open)
+			r.inline(text[i+run : end])
+			r.b.WriteString(close)
+			return after - i
+		}
+		j = end + 1
+		for j < len(text) && text[j] == c {
+			j++
+		}
+	}
+	unclosed[delimiter] = true
+	return 0
+}
+
+// isWordChar returns whether the last (or first) character of text is a
+// letter or digit.
+func isWordChar(text string, last bool) bool {
+	var ch rune
+	if last {
+		ch, _ = utf8.DecodeLastRuneInString(text)
+	} else {
+		ch, _ = utf8.DecodeRuneInString(text)
+	}
+	return unicode.IsLetter(ch) || unicode.IsDigit(ch)
+}
+
+// url checks a link target and makes relative targets relative to the
+// location of the document. Targets using other schemes than web, mail and
+// FTP addresses are refused.
+func (r *renderer) url(target string) (string, bool) {
+	target = strings.TrimSpace(target)
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", false
+	}
+	if len(u.Scheme) > 0 {
+		switch strings.ToLower(u.Scheme) {
+		case "http", "https", "mailto", "ftp":
+			return target, true
+		}
+		return "", false
+	}
+	if len(u.Host) > 0 || strings.HasPrefix(target, "/") ||
+		strings.HasPrefix(target, "#") || strings.HasPrefix(target, "?") {
+		return target, true
+	}
+	return r.options.LinkPrefix + target, true
+}
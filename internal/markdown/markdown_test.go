@@ -0,0 +1,86 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRender(t *testing.T) {
+	for _, test := range []struct {
+		src, want string
+	}{
+		{
+			"# Title\n\nSome *emphasis*, **strong**, ***both***, ~~gone~~ and `code`.",
+			"<h1>Title</h1>\n<p>Some <em>emphasis</em>, <strong>strong</strong>, " +
+				"<strong><em>both</em></strong>, <del>gone</del> and <code>code</code>.</p>\n",
+		},
+		{
+			"snake_case_name and _under_ but 2 * 3 * 4",
+			"<p>snake_case_name and <em>under</em> but 2 * 3 * 4</p>\n",
+		},
+		{
+			"a *b **c** d* e",
+			"<p>a <em>b <strong>c</strong> d</em> e</p>\n",
+		},
+		{
+			"\\*not\\* emphasized  \nbut broken",
+			"<p>*not* emphasized  <br />\nbut broken</p>\n",
+		},
+		{
+			"``code with ` inside`` and `unclosed",
+			"<p><code>code with ` inside</code> and `unclosed</p>\n",
+		},
+		{
+			"[link](docs/a.md \"Title\") ![img](x.png) <https://example.com> https://example.com.",
+			`<p><a href="/p/docs/a.md" title="Title">link</a> <img src="/p/x.png" alt="img" /> ` +
+				`<a href="https://example.com">https://example.com</a> ` +
+				`<a href="https://example.com">https://example.com</a>.</p>` + "\n",
+		},
+		{
+			"[[nested] text](/abs) [unclosed",
+			`<p><a href="/abs">[nested] text</a> [unclosed</p>` + "\n",
+		},
+		{
+			"[bad](javascript:alert(1)) <script>alert(1)</script>",
+			"<p>bad &lt;script&gt;alert(1)&lt;/script&gt;</p>\n",
+		},
+		{
+			"- one\n- two\n\n1. first\n2. second",
+			"<ul>\n<li>one\n</li>\n<li>two\n</li>\n</ul>\n<ol>\n<li>first\n</li>\n<li>second\n</li>\n</ol>\n",
+		},
+		{
+			"```go\nfunc main() {} // hi\n```",
+			`<pre><code><span class="hl-k">func</span> main() {} <span class="hl-c">// hi</span></code></pre>` + "\n",
+		},
+		{
+			"> quote\n\n---\n\n    indented",
+			"<blockquote>\n<p>quote</p>\n</blockquote>\n<hr />\n<pre><code>indented</code></pre>\n",
+		},
+	} {
+		if got := Render([]byte(test.src), &Options{LinkPrefix: "/p/"}); got != test.want {
+			t.Errorf("Render(%q) =\n%q\nwant\n%q", test.src, got, test.want)
+		}
+	}
+}
+
+func TestRenderNesting(t *testing.T) {
+	src := strings.Repeat("[", 100) + "a" + strings.Repeat("](b)", 100)
+	got := Render([]byte(src), nil)
+	if n := strings.Count(got, "<a "); n != maxNesting {
+		t.Errorf("got %d nested elements, want %d", n, maxNesting)
+	}
+}
+
+// TestRenderUnclosed checks that markup without an end does not make
+// rendering take quadratic time.
+func TestRenderUnclosed(t *testing.T) {
+	for _, markup := range []string{"*a ", "**a ", "_a ", "~~a ", "``a `", "[a ", "![a ", "[a](b [a]("} {
+		src := strings.Repeat(markup, 100000)
+		start := time.Now()
+		Render([]byte(src), nil)
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("rendering %q repeatedly took %s", markup, elapsed)
+		}
+	}
+}
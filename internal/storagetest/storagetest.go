@@ -0,0 +1,46 @@
+// Package storagetest sets up storages for tests.
+package storagetest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kthxat/filament/backends"
+	_ "github.com/kthxat/filament/backends/local"
+	"github.com/spf13/viper"
+)
+
+// Local returns a storage of a temporary directory containing the given
+// files, keyed by their slash-separated paths. Paths ending in a slash are
+// created as empty directories.
+func Local(t testing.TB, files map[string]string) backends.Storage {
+	t.Helper()
+
+	root := t.TempDir()
+	for p, content := range files {
+		name := filepath.Join(root, filepath.FromSlash(p))
+		if strings.HasSuffix(p, "/") {
+			if err := os.MkdirAll(name, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := viper.New()
+	cfg.Set("Root", root)
+	backend, err := backends.GetByID("local").New(&backends.BackendConstructionParams{Config: cfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { backend.Close() })
+	return backend.(backends.Storage)
+}
@@ -0,0 +1,100 @@
+// Package thumbnail makes small previews of images using the decoders of the
+// standard library.
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io"
+
+	// Register the supported formats
+	_ "image/gif"
+	_ "image/png"
+)
+
+// ErrTooLarge is returned for images with more pixels than allowed.
+var ErrTooLarge = errors.New("image is too large")
+
+// Quality is the JPEG quality thumbnails are encoded with.
+const Quality = 80
+
+// Make decodes a JPEG, PNG or GIF image, scales it down to fit into a square
+// of the given size and writes it to w as JPEG. Images are never scaled up.
+// Images with more than maxPixels pixels are refused before decoding them, to
+// protect against images that take up huge amounts of memory once decoded.
+func Make(data []byte, w io.Writer, size, maxPixels int) error {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if maxPixels > 0 && config.Width*config.Height > maxPixels {
+		return fmt.Errorf("%w: %d×%d pixels", ErrTooLarge, config.Width, config.Height)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	return jpeg.Encode(w, Scale(src, size), &jpeg.Options{Quality: Quality})
+}
+
+// Scale scales an image down to fit into a square of the given size,
+// averaging the pixels that make up each pixel of the result. Transparent
+// parts are put on white background.
+func Scale(src image.Image, size int) *image.RGBA {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width > height {
+			width, height = size, max(1, height*size/width)
+		} else {
+			width, height = max(1, width*size/height), size
+		}
+	}
+
+	// Drawing into an RGBA image first is much faster than looking at every
+	// pixel of an arbitrary image through its interface
+	rgba, ok := src.(*image.RGBA)
+	if !ok {
+		rgba = image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+	}
+	srcWidth, srcHeight := rgba.Bounds().Dx(), rgba.Bounds().Dy()
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := y * srcHeight / height
+		y1 := max(y0+1, (y+1)*srcHeight/height)
+		for x := 0; x < width; x++ {
+			x0 := x * srcWidth / width
+			x1 := max(x0+1, (x+1)*srcWidth/width)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				row := rgba.Pix[sy*rgba.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += uint64(p[0])
+					g += uint64(p[1])
+					b += uint64(p[2])
+					a += uint64(p[3])
+					n++
+				}
+			}
+
+			// Colors are premultiplied with alpha, so adding what is
+			// missing to full opacity puts them on white
+			white := 255*n - a
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8((r + white) / n)
+			dst.Pix[i+1] = uint8((g + white) / n)
+			dst.Pix[i+2] = uint8((b + white) / n)
+			dst.Pix[i+3] = 255
+		}
+	}
+	return dst
+}
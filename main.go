@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
+	"github.com/kthxat/filament/app"
 	"github.com/kthxat/filament/config"
 	"github.com/kthxat/filament/frontend"
 )
@@ -56,6 +57,8 @@ func main() {
 	config.ReadConfig(appID)
 	spew.Dump(config.GetConfig())
 
+	app.StartIndexing()
+
 	server := frontend.NewFrontendServer(config.GetConfig().HTTP)
 	go func() {
 		if err := server.ListenAndServe(); errors.Is(err, http.ErrServerClosed) {
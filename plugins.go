@@ -2,4 +2,5 @@ package main
 
 import (
 	_ "github.com/kthxat/filament/backends/ftp"
+	_ "github.com/kthxat/filament/backends/local"
 )